@@ -1,7 +1,11 @@
 package config
 
 import (
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // GetPort returns the server port from environment or default
@@ -39,3 +43,471 @@ func GetJWTSecret() string {
 	}
 	return secret
 }
+
+// GetAppBaseURL returns the public URL of the frontend, used to build links in emails
+func GetAppBaseURL() string {
+	url := os.Getenv("APP_BASE_URL")
+	if url == "" {
+		url = "http://localhost:5173"
+	}
+	return strings.TrimRight(url, "/")
+}
+
+// GetMailDriver returns the mail driver to use (smtp, log or file)
+func GetMailDriver() string {
+	driver := os.Getenv("MAIL_DRIVER")
+	if driver == "" {
+		driver = "log"
+	}
+	return driver
+}
+
+// GetMailFrom returns the sender address for outgoing emails
+func GetMailFrom() string {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "VicNotes <no-reply@vicnotes.local>"
+	}
+	return from
+}
+
+// GetSMTPAddr returns the SMTP server address as host:port
+func GetSMTPAddr() string {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = "1025"
+	}
+
+	return host + ":" + port
+}
+
+// GetSMTPCredentials returns the SMTP username and password, empty when auth is disabled
+func GetSMTPCredentials() (string, string) {
+	return os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")
+}
+
+// GetMailDir returns the directory where the file mail driver writes messages
+func GetMailDir() string {
+	dir := os.Getenv("MAIL_DIR")
+	if dir == "" {
+		dir = "mail"
+	}
+	return dir
+}
+
+// GetPasswordResetTTL returns how long a password reset token stays valid
+func GetPasswordResetTTL() time.Duration {
+	return getDuration("PASSWORD_RESET_TTL", 1*time.Hour)
+}
+
+// getDuration reads a duration from the environment, falling back to a default
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// GetEmailVerificationTTL returns how long an email verification token stays valid
+func GetEmailVerificationTTL() time.Duration {
+	return getDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+}
+
+// RequireEmailVerification reports whether unverified accounts are blocked from logging in
+func RequireEmailVerification() bool {
+	return getBool("REQUIRE_EMAIL_VERIFICATION", false)
+}
+
+// getBool reads a boolean from the environment, falling back to a default
+func getBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback
+	}
+	return b
+}
+
+// GetTOTPIssuer returns the issuer name shown in authenticator apps
+func GetTOTPIssuer() string {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "VicNotes"
+	}
+	return issuer
+}
+
+// TrustProxyHeaders reports whether the X-Real-IP header set by the reverse proxy identifies the client
+func TrustProxyHeaders() bool {
+	return getBool("TRUST_PROXY_HEADERS", false)
+}
+
+// GetLoginMaxFailuresPerAccount returns how many failed logins lock an account
+func GetLoginMaxFailuresPerAccount() int {
+	return getInt("LOGIN_MAX_FAILURES_PER_ACCOUNT", 5)
+}
+
+// GetLoginMaxFailuresPerIP returns how many failed logins lock a source IP
+func GetLoginMaxFailuresPerIP() int {
+	return getInt("LOGIN_MAX_FAILURES_PER_IP", 20)
+}
+
+// GetLoginLockoutDuration returns how long a locked account or IP stays locked
+func GetLoginLockoutDuration() time.Duration {
+	return getDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+}
+
+// getInt reads a positive integer from the environment, falling back to a default
+func getInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
+
+// GetPasswordMinLength returns the minimum password length in characters
+func GetPasswordMinLength() int {
+	return getInt("PASSWORD_MIN_LENGTH", 8)
+}
+
+// GetPasswordMaxLength returns the maximum password length in characters
+func GetPasswordMaxLength() int {
+	return getInt("PASSWORD_MAX_LENGTH", 128)
+}
+
+// GetPasswordHashAlgorithm returns the algorithm for new password hashes (argon2id or bcrypt)
+func GetPasswordHashAlgorithm() string {
+	if os.Getenv("PASSWORD_HASH_ALGORITHM") == "bcrypt" {
+		return "bcrypt"
+	}
+	return "argon2id"
+}
+
+// GetBcryptCost returns the bcrypt cost for new hashes
+func GetBcryptCost() int {
+	return getInt("BCRYPT_COST", 12)
+}
+
+// GetArgon2Memory returns the argon2id memory cost in KiB
+func GetArgon2Memory() int {
+	return getInt("ARGON2_MEMORY", 64*1024)
+}
+
+// GetArgon2Time returns the argon2id number of passes
+func GetArgon2Time() int {
+	return getInt("ARGON2_TIME", 3)
+}
+
+// GetArgon2Threads returns the argon2id degree of parallelism, at most 255,
+// the largest value argon2 accepts
+func GetArgon2Threads() int {
+	threads := getInt("ARGON2_THREADS", 2)
+	if threads > 255 {
+		return 255
+	}
+	return threads
+}
+
+// GetAccountDeletionGrace returns how long a deleted account can still be restored
+func GetAccountDeletionGrace() time.Duration {
+	return getDuration("ACCOUNT_DELETION_GRACE", 7*24*time.Hour)
+}
+
+// GetNoteRevisionLimit returns how many revisions are kept per note, newest first
+func GetNoteRevisionLimit() int {
+	return getInt("NOTE_REVISION_LIMIT", 100)
+}
+
+// GetNoteRevisionMaxAge returns how long revisions are kept, or 0 to keep them
+// until the revision limit drops them. A note's latest revision is always kept.
+func GetNoteRevisionMaxAge() time.Duration {
+	return getDuration("NOTE_REVISION_MAX_AGE", 0)
+}
+
+// GetTrashRetention returns how long deleted notes stay in the trash before they are purged
+func GetTrashRetention() time.Duration {
+	return getDuration("TRASH_RETENTION", 30*24*time.Hour)
+}
+
+// RequireNoteIfMatch reports whether note updates and deletes must send If-Match
+func RequireNoteIfMatch() bool {
+	return getBool("NOTE_REQUIRE_IF_MATCH", false)
+}
+
+// GetAPIBaseURL returns the public URL of this API, used to build download links in emails and public share links
+func GetAPIBaseURL() string {
+	url := os.Getenv("API_BASE_URL")
+	if url == "" {
+		url = "http://localhost:" + GetPort()
+	}
+	return strings.TrimRight(url, "/")
+}
+
+// OIDCEnabled reports whether OpenID Connect login is configured
+func OIDCEnabled() bool {
+	return GetOIDCIssuerURL() != "" && GetOIDCClientID() != ""
+}
+
+// GetOIDCIssuerURL returns the OpenID Connect provider's issuer URL
+func GetOIDCIssuerURL() string {
+	return os.Getenv("OIDC_ISSUER_URL")
+}
+
+// GetOIDCClientID returns the client ID registered with the OpenID Connect provider
+func GetOIDCClientID() string {
+	return os.Getenv("OIDC_CLIENT_ID")
+}
+
+// GetOIDCClientSecret returns the client secret, empty for public clients
+func GetOIDCClientSecret() string {
+	return os.Getenv("OIDC_CLIENT_SECRET")
+}
+
+// GetOIDCRedirectURL returns the callback URL registered with the OpenID Connect provider
+func GetOIDCRedirectURL() string {
+	url := os.Getenv("OIDC_REDIRECT_URL")
+	if url == "" {
+		url = GetAPIBaseURL() + "/api/v1/auth/oidc/callback"
+	}
+	return url
+}
+
+// GetOIDCScopes returns the scopes requested from the OpenID Connect provider
+func GetOIDCScopes() []string {
+	scopes := os.Getenv("OIDC_SCOPES")
+	if scopes == "" {
+		scopes = "openid email profile"
+	}
+	return strings.Fields(scopes)
+}
+
+// OIDCAutoProvision reports whether unknown OpenID Connect users get an account automatically
+func OIDCAutoProvision() bool {
+	return getBool("OIDC_AUTO_PROVISION", true)
+}
+
+// MagicLinkEnabled reports whether passwordless login by email link is available
+func MagicLinkEnabled() bool {
+	return getBool("MAGIC_LINK_ENABLED", false)
+}
+
+// GetMagicLinkTTL returns how long a magic login link stays valid
+func GetMagicLinkTTL() time.Duration {
+	return getDuration("MAGIC_LINK_TTL", 15*time.Minute)
+}
+
+// GetMagicLinkMaxPerEmail returns how many magic links one email can request per window
+func GetMagicLinkMaxPerEmail() int {
+	return getInt("MAGIC_LINK_MAX_PER_EMAIL", 3)
+}
+
+// GetMagicLinkWindow returns the window for the per-email magic link limit
+func GetMagicLinkWindow() time.Duration {
+	return getDuration("MAGIC_LINK_WINDOW", 15*time.Minute)
+}
+
+// Registration modes
+const (
+	RegistrationOpen     = "open"
+	RegistrationDisabled = "disabled"
+	RegistrationInvite   = "invite"
+	RegistrationDomain   = "domain"
+)
+
+// GetRegistrationMode returns who may register: open, disabled, invite or domain
+func GetRegistrationMode() string {
+	switch mode := os.Getenv("REGISTRATION_MODE"); mode {
+	case RegistrationDisabled, RegistrationInvite, RegistrationDomain:
+		return mode
+	default:
+		return RegistrationOpen
+	}
+}
+
+// GetAllowedEmailDomains returns the lowercase domains accepted in domain registration mode
+func GetAllowedEmailDomains() []string {
+	return getList("ALLOWED_EMAIL_DOMAINS")
+}
+
+// GetAdminEmails returns the lowercase emails of users allowed to use the admin API
+func GetAdminEmails() []string {
+	return getList("ADMIN_EMAILS")
+}
+
+// Session modes
+const (
+	SessionBearer = "bearer"
+	SessionCookie = "cookie"
+)
+
+// GetSessionMode returns how session tokens are handed to clients: in the response
+// body for use as a Bearer token (default) or as an HttpOnly cookie
+func GetSessionMode() string {
+	if os.Getenv("SESSION_MODE") == SessionCookie {
+		return SessionCookie
+	}
+	return SessionBearer
+}
+
+// GetSessionCookieName returns the name of the HttpOnly session cookie
+func GetSessionCookieName() string {
+	if name := os.Getenv("SESSION_COOKIE_NAME"); name != "" {
+		return name
+	}
+	return "vicnotes_session"
+}
+
+// GetCSRFCookieName returns the name of the cookie holding the CSRF token
+func GetCSRFCookieName() string {
+	if name := os.Getenv("CSRF_COOKIE_NAME"); name != "" {
+		return name
+	}
+	return "vicnotes_csrf"
+}
+
+// GetSessionCookieDomain returns the Domain attribute of the session cookies, empty for host-only
+func GetSessionCookieDomain() string {
+	return os.Getenv("SESSION_COOKIE_DOMAIN")
+}
+
+// SessionCookieSecure reports whether session cookies are only sent over HTTPS
+func SessionCookieSecure() bool {
+	return getBool("SESSION_COOKIE_SECURE", true)
+}
+
+// GetSessionCookieSameSite returns the SameSite attribute of the session cookies: lax, strict or none
+func GetSessionCookieSameSite() string {
+	switch mode := strings.ToLower(os.Getenv("SESSION_COOKIE_SAMESITE")); mode {
+	case "strict", "none":
+		return mode
+	default:
+		return "lax"
+	}
+}
+
+// GetCORSAllowedOrigins returns the origins allowed to call the API from a browser.
+// It defaults to the origin of APP_BASE_URL; "*" allows any origin.
+func GetCORSAllowedOrigins() []string {
+	if origins := getList("CORS_ALLOWED_ORIGINS"); len(origins) > 0 {
+		return origins
+	}
+
+	u, err := url.Parse(GetAppBaseURL())
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil
+	}
+	return []string{strings.ToLower(u.Scheme + "://" + u.Host)}
+}
+
+// GetCORSAllowedMethods returns the methods allowed in cross-origin requests
+func GetCORSAllowedMethods() []string {
+	methods := getList("CORS_ALLOWED_METHODS")
+	if len(methods) == 0 {
+		return []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	}
+	for i, method := range methods {
+		methods[i] = strings.ToUpper(method)
+	}
+	return methods
+}
+
+// GetCORSAllowedHeaders returns the request headers allowed in cross-origin requests
+func GetCORSAllowedHeaders() []string {
+	if headers := getList("CORS_ALLOWED_HEADERS"); len(headers) > 0 {
+		return headers
+	}
+	return []string{"authorization", "content-type", "x-csrf-token", "x-request-id", "x-workspace-id", "if-match", "if-none-match"}
+}
+
+// GetCORSExposedHeaders returns the response headers browsers may read cross-origin
+func GetCORSExposedHeaders() []string {
+	if headers := getList("CORS_EXPOSED_HEADERS"); len(headers) > 0 {
+		return headers
+	}
+	return []string{"etag", "x-request-id", "retry-after", "ratelimit-limit", "ratelimit-remaining", "ratelimit-reset", "ratelimit-policy"}
+}
+
+// CORSAllowCredentials reports whether cross-origin requests may carry cookies.
+// It defaults to on in cookie session mode, and is ignored when any origin is allowed.
+func CORSAllowCredentials() bool {
+	return getBool("CORS_ALLOW_CREDENTIALS", GetSessionMode() == SessionCookie)
+}
+
+// GetCORSMaxAge returns how long browsers may cache a preflight response
+func GetCORSMaxAge() time.Duration {
+	return getDuration("CORS_MAX_AGE", 10*time.Minute)
+}
+
+// RateLimitEnabled reports whether API requests are rate limited
+func RateLimitEnabled() bool {
+	return getBool("RATE_LIMIT_ENABLED", true)
+}
+
+// GetRateLimitStore returns where rate limit buckets are kept (memory or redis)
+func GetRateLimitStore() string {
+	if os.Getenv("RATE_LIMIT_STORE") == "redis" {
+		return "redis"
+	}
+	return "memory"
+}
+
+// GetRedisURL returns the Redis connection URL
+func GetRedisURL() string {
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		return url
+	}
+	return "redis://localhost:6379/0"
+}
+
+// GetRateLimit returns the limit for a named route group as a request count per window.
+// RATE_LIMIT_<NAME> overrides it with a value such as "60/1m".
+func GetRateLimit(name string, requests int, window time.Duration) (int, time.Duration) {
+	value := os.Getenv("RATE_LIMIT_" + strings.ToUpper(name))
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return requests, window
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || n <= 0 {
+		return requests, window
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || d <= 0 {
+		return requests, window
+	}
+	return n, d
+}
+
+// getList reads a comma-separated, case-insensitive list from the environment
+func getList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
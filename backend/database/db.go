@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"vicnotes/backend/config"
 	"vicnotes/backend/utils"
 )
@@ -68,6 +68,247 @@ func RunMigrations(db *sql.DB) error {
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_notes_user_id ON notes(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_notes_created_at ON notes(created_at)`,
+		`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id ON password_reset_tokens(user_id)`,
+		// Accounts from before email verification count as verified, new ones don't
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'email_verified') THEN
+				ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT TRUE;
+				ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT FALSE;
+			END IF;
+		END
+		$$`,
+		// Emails differing only in case must be merged by hand before the index can be built
+		`DO $$
+		DECLARE
+			duplicate TEXT;
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'idx_users_email_lower') THEN
+				SELECT LOWER(email) INTO duplicate FROM users GROUP BY LOWER(email) HAVING COUNT(*) > 1 LIMIT 1;
+				IF duplicate IS NOT NULL THEN
+					RAISE EXCEPTION 'several users have the email % in different cases; rename or delete all but one before upgrading', duplicate;
+				END IF;
+				CREATE UNIQUE INDEX idx_users_email_lower ON users(LOWER(email));
+			END IF;
+		END
+		$$`,
+		`CREATE TABLE IF NOT EXISTS email_verification_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(255) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user_id ON email_verification_tokens(user_id)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT`,
+		`CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash VARCHAR(64) NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes(user_id)`,
+		`CREATE TABLE IF NOT EXISTS login_lockouts (
+			id SERIAL PRIMARY KEY,
+			scope VARCHAR(16) NOT NULL,
+			identifier VARCHAR(255) NOT NULL,
+			ip_address VARCHAR(45) NOT NULL,
+			failures INTEGER NOT NULL,
+			locked_until TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_login_lockouts_identifier ON login_lockouts(identifier)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMP`,
+		`ALTER TABLE email_verification_tokens ADD COLUMN IF NOT EXISTS purpose VARCHAR(16) NOT NULL DEFAULT 'verify'`,
+		`CREATE TABLE IF NOT EXISTS oidc_identities (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			issuer VARCHAR(255) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			email VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (issuer, subject)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_oidc_identities_user_id ON oidc_identities(user_id)`,
+		`CREATE TABLE IF NOT EXISTS magic_link_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_magic_link_tokens_user_id ON magic_link_tokens(user_id)`,
+		`CREATE TABLE IF NOT EXISTS invite_codes (
+			id SERIAL PRIMARY KEY,
+			code_hash VARCHAR(64) UNIQUE NOT NULL,
+			code_hint VARCHAR(8) NOT NULL,
+			max_uses INTEGER NOT NULL DEFAULT 1,
+			use_count INTEGER NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user'`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGSERIAL PRIMARY KEY,
+			user_id INTEGER,
+			actor_id INTEGER,
+			action VARCHAR(64) NOT NULL,
+			target_type VARCHAR(32),
+			target_id INTEGER,
+			ip_address VARCHAR(64),
+			user_agent TEXT,
+			request_id VARCHAR(64),
+			details JSONB,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_user_id ON audit_log(user_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, id)`,
+		// The audit log is append-only, even for the application's own role
+		`CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_log is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log`,
+		`CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+			FOR EACH ROW EXECUTE FUNCTION audit_log_append_only()`,
+		`CREATE TABLE IF NOT EXISTS workspaces (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			personal_user_id INTEGER UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS workspace_members (
+			workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			role VARCHAR(16) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (workspace_id, user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_workspace_members_user_id ON workspace_members(user_id)`,
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_workspace_id ON notes(workspace_id)`,
+		// Give every user a personal workspace and move notes from before workspaces into it
+		`INSERT INTO workspaces (name, personal_user_id, created_by)
+			SELECT 'Personal', u.id, u.id FROM users u
+			WHERE NOT EXISTS (SELECT 1 FROM workspaces w WHERE w.personal_user_id = u.id)`,
+		`INSERT INTO workspace_members (workspace_id, user_id, role)
+			SELECT id, personal_user_id, 'owner' FROM workspaces WHERE personal_user_id IS NOT NULL
+			ON CONFLICT DO NOTHING`,
+		`UPDATE notes SET workspace_id = w.id FROM workspaces w
+			WHERE notes.workspace_id IS NULL AND w.personal_user_id = notes.user_id`,
+		`ALTER TABLE notes ALTER COLUMN workspace_id SET NOT NULL`,
+		`CREATE TABLE IF NOT EXISTS note_shares (
+			note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			permission VARCHAR(16) NOT NULL CHECK (permission IN ('editor', 'viewer')),
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (note_id, user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_note_shares_user_id ON note_shares(user_id)`,
+		`CREATE TABLE IF NOT EXISTS note_links (
+			id SERIAL PRIMARY KEY,
+			note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			token_hint VARCHAR(8) NOT NULL,
+			password_hash VARCHAR(255),
+			expires_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_note_links_note_id ON note_links(note_id)`,
+		`CREATE TABLE IF NOT EXISTS tags (
+			id SERIAL PRIMARY KEY,
+			workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+			name VARCHAR(64) NOT NULL,
+			color VARCHAR(7) NOT NULL DEFAULT '#6b7280',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_workspace_name ON tags(workspace_id, LOWER(name))`,
+		`CREATE TABLE IF NOT EXISTS note_tags (
+			note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+			PRIMARY KEY (note_id, tag_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_note_tags_tag_id ON note_tags(tag_id)`,
+		`CREATE TABLE IF NOT EXISTS notebooks (
+			id SERIAL PRIMARY KEY,
+			workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+			parent_id INTEGER REFERENCES notebooks(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_notebooks_workspace_id ON notebooks(workspace_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_notebooks_parent_id ON notebooks(parent_id)`,
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS notebook_id INTEGER REFERENCES notebooks(id) ON DELETE SET NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_notebook_id ON notes(notebook_id)`,
+		// Notes are indexed in their author's search language
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS search_language VARCHAR(64) NOT NULL DEFAULT 'simple'`,
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS search_language REGCONFIG NOT NULL DEFAULT 'simple'`,
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+			setweight(to_tsvector(search_language, COALESCE(title, '')), 'A') ||
+			setweight(to_tsvector(search_language, COALESCE(content, '')), 'B')
+		) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_search_vector ON notes USING GIN (search_vector)`,
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE TABLE IF NOT EXISTS saved_searches (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			query TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_saved_searches_user_id ON saved_searches(user_id)`,
+		`CREATE TABLE IF NOT EXISTS note_revisions (
+			id SERIAL PRIMARY KEY,
+			note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			title VARCHAR(255) NOT NULL,
+			content TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_note_revisions_note_id ON note_revisions(note_id, id)`,
+		// Notes written before revisions existed start their history with their current state
+		`INSERT INTO note_revisions (note_id, user_id, title, content, created_at)
+		SELECT n.id, n.user_id, n.title, COALESCE(n.content, ''), n.updated_at FROM notes n
+		WHERE NOT EXISTS (SELECT 1 FROM note_revisions r WHERE r.note_id = n.id)`,
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_deleted_at ON notes(deleted_at) WHERE deleted_at IS NOT NULL`,
+		`CREATE TABLE IF NOT EXISTS account_export_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_account_export_tokens_user_id ON account_export_tokens(user_id)`,
 	}
 
 	for _, migration := range migrations {
@@ -78,3 +319,19 @@ func RunMigrations(db *sql.DB) error {
 
 	return nil
 }
+
+// BootstrapAdmins gives the admin role to existing users whose email is listed
+func BootstrapAdmins(db *sql.DB, emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+
+	if _, err := db.Exec(
+		"UPDATE users SET role = 'admin' WHERE LOWER(email) = ANY($1) AND role <> 'admin'",
+		pq.Array(emails),
+	); err != nil {
+		return fmt.Errorf("failed to bootstrap admins: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,291 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Op is the kind of a change
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+// Change is a run of text kept, inserted or deleted
+type Change struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxEdits bounds the work spent on a diff. Beyond it, the remaining middle of the
+// texts is reported as deleted and inserted whole.
+const maxEdits = 1000
+
+// edit is one token of a diff: a token of a, of b, or of both
+type edit struct {
+	op    Op
+	token string
+}
+
+// Words returns the changes turning a into b, word by word. Whitespace is kept,
+// so joining the Equal and Insert texts gives b back.
+func Words(a, b string) []Change {
+	var changes []Change
+	for _, e := range tokens(splitWords(a), splitWords(b)) {
+		if n := len(changes); n > 0 && changes[n-1].Op == e.op {
+			changes[n-1].Text += e.token
+			continue
+		}
+		changes = append(changes, Change{Op: e.op, Text: e.token})
+	}
+	if changes == nil {
+		changes = []Change{}
+	}
+	return changes
+}
+
+// Unified returns a unified diff of a and b labelled from and to, with context
+// lines around each change. It is empty when the texts are equal.
+func Unified(a, b, from, to string, context int) string {
+	edits := tokens(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	for _, h := range hunks(edits, context) {
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", from, to)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(h.aStart, h.aLines), hunkRange(h.bStart, h.bLines))
+		for _, e := range edits[h.start:h.end] {
+			switch e.op {
+			case Equal:
+				out.WriteByte(' ')
+			case Delete:
+				out.WriteByte('-')
+			case Insert:
+				out.WriteByte('+')
+			}
+			out.WriteString(e.token)
+			out.WriteByte('\n')
+		}
+	}
+	return out.String()
+}
+
+// hunk is a group of nearby changes with their context, edits[start:end]
+type hunk struct {
+	start, end     int
+	aStart, aLines int
+	bStart, bLines int
+}
+
+// hunks groups changed edits with up to context equal lines around them,
+// merging groups whose context would overlap
+func hunks(edits []edit, context int) []hunk {
+	var result []hunk
+	aLine, bLine := 0, 0
+	for i := 0; i < len(edits); {
+		if edits[i].op == Equal {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+
+		// Back up over the leading context
+		lead := 0
+		for lead < context && i-lead > 0 && edits[i-lead-1].op == Equal {
+			lead++
+		}
+		h := hunk{start: i - lead, aStart: aLine - lead, bStart: bLine - lead, aLines: lead, bLines: lead}
+
+		// Extend while the next change is within two contexts of the last one
+		end := i
+		for end < len(edits) {
+			if edits[end].op != Equal {
+				end++
+				continue
+			}
+			run := 0
+			for end+run < len(edits) && edits[end+run].op == Equal {
+				run++
+			}
+			if end+run == len(edits) || run > 2*context {
+				if run > context {
+					run = context
+				}
+				end += run
+				break
+			}
+			end += run
+		}
+		h.end = end
+
+		for _, e := range edits[i:end] {
+			if e.op != Insert {
+				h.aLines++
+				aLine++
+			}
+			if e.op != Delete {
+				h.bLines++
+				bLine++
+			}
+		}
+		result = append(result, h)
+		i = end
+	}
+	return result
+}
+
+// hunkRange formats the line range of a hunk side. Lines are 1-based; an empty
+// side is given as the line before it.
+func hunkRange(start, lines int) string {
+	if lines == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if lines == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, lines)
+}
+
+// tokens diffs two token lists, keeping their common prefix and suffix out of the search
+func tokens(a, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]edit, 0, len(a)+len(b))
+	for _, t := range a[:prefix] {
+		edits = append(edits, edit{Equal, t})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, t := range a[len(a)-suffix:] {
+		edits = append(edits, edit{Equal, t})
+	}
+	return edits
+}
+
+// myers finds a shortest edit script from a to b with Myers' O(ND) algorithm
+func myers(a, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m
+	v := make([]int, 2*offset+2)
+
+	// trace[d] holds the furthest x on diagonals -d..d before round d
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		if d > maxEdits {
+			return replace(a, b)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(a, b, trace)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the trace of myers from the end of both lists back to the start
+func backtrack(a, b []string, trace [][]int) []edit {
+	var reversed []edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = at(prevK)
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, edit{Equal, a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, edit{Insert, b[y-1]})
+			} else {
+				reversed = append(reversed, edit{Delete, a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	edits := make([]edit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
+
+// replace reports a as deleted and b as inserted
+func replace(a, b []string) []edit {
+	edits := make([]edit, 0, len(a)+len(b))
+	for _, t := range a {
+		edits = append(edits, edit{Delete, t})
+	}
+	for _, t := range b {
+		edits = append(edits, edit{Insert, t})
+	}
+	return edits
+}
+
+// splitLines splits text into lines without their line breaks
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// splitWords splits text into alternating runs of whitespace and other characters
+func splitWords(text string) []string {
+	var words []string
+	start, space := 0, false
+	for i, r := range text {
+		if i > start && unicode.IsSpace(r) != space {
+			words = append(words, text[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
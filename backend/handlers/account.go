@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"vicnotes/backend/config"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+// ChangePassword sets a new password after checking the current one.
+// Every other session is revoked and the caller receives a fresh token.
+func ChangePassword(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		var req models.ChangePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		if req.CurrentPassword == "" || req.NewPassword == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Current and new password are required",
+			})
+			return
+		}
+
+		var user models.User
+		var passwordHash string
+		err := cb.Call(func() error {
+			return db.QueryRow(
+				"SELECT id, email, email_verified, totp_enabled, role, password_hash FROM users WHERE id = $1",
+				userID,
+			).Scan(&user.ID, &user.Email, &user.EmailVerified, &user.TOTPEnabled, &user.Role, &passwordHash)
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to query user",
+			})
+			return
+		}
+
+		if !utils.VerifyPassword(passwordHash, req.CurrentPassword) {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_credentials",
+				Message: "Current password is incorrect",
+			})
+			return
+		}
+
+		if err := utils.ValidatePassword(req.NewPassword, user.Email); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "weak_password",
+				Message: err.Error(),
+			})
+			return
+		}
+
+		newHash, err := utils.HashPassword(req.NewPassword)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to process password",
+			})
+			return
+		}
+
+		var tokenVersion int
+		err = cb.Call(func() error {
+			if err := db.QueryRow(
+				"UPDATE users SET password_hash = $1, token_version = token_version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING token_version",
+				newHash, userID,
+			).Scan(&tokenVersion); err != nil {
+				return err
+			}
+			// Links sent before the change may have reached someone else
+			return revokeExportLinks(db, userID)
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to update password",
+			})
+			return
+		}
+
+		cache.Delete(fmt.Sprintf("auth:%d", userID))
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditPasswordChange})
+		recordTokensRevoked(db, cb, r, userID, auditPasswordChange)
+
+		// Keep the current session alive with a token for the new version
+		token, err := utils.GenerateToken(user.ID, user.Email, tokenVersion)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to generate token",
+			})
+			return
+		}
+
+		token = deliverToken(w, token)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.AuthResponse{
+			Token: token,
+			User:  user,
+		})
+	}
+}
+
+// ChangeEmail starts an email change by sending a verification link to the new address.
+// The account keeps its current email until the link is used.
+func ChangeEmail(db *sql.DB, cb *utils.CircuitBreaker, mailer utils.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		var req models.ChangeEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		newEmail := utils.NormalizeEmail(req.NewEmail)
+		if req.Password == "" || newEmail == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Password and new email are required",
+			})
+			return
+		}
+
+		if !utils.ValidateEmail(newEmail) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_email",
+				Message: "Email address is not valid",
+			})
+			return
+		}
+
+		var currentEmail, passwordHash string
+		err := cb.Call(func() error {
+			return db.QueryRow("SELECT email, password_hash FROM users WHERE id = $1", userID).Scan(&currentEmail, &passwordHash)
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to query user",
+			})
+			return
+		}
+
+		if !utils.VerifyPassword(passwordHash, req.Password) {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_credentials",
+				Message: "Password is incorrect",
+			})
+			return
+		}
+
+		if newEmail == utils.NormalizeEmail(currentEmail) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "New email is the same as the current one",
+			})
+			return
+		}
+
+		var taken bool
+		err = cb.Call(func() error {
+			return db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = $1)", newEmail).Scan(&taken)
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to query user",
+			})
+			return
+		}
+
+		if taken {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "email_taken",
+				Message: "This email is already used by another account",
+			})
+			return
+		}
+
+		if err := sendVerificationEmail(db, cb, mailer, userID, newEmail, verifyPurposeChange); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to send verification email",
+			})
+			return
+		}
+
+		// Let the owner of the current address know in case this wasn't them
+		go func() {
+			body := fmt.Sprintf(
+				"A request was made to change the email of your VicNotes account to %s.\n\n"+
+					"The change takes effect once the new address is verified. "+
+					"If you didn't request this, change your password right away.",
+				newEmail,
+			)
+			if err := mailer.Send(currentEmail, "Your VicNotes email is being changed", body); err != nil {
+				log.Printf("Failed to send email change notice: %v", err)
+			}
+		}()
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "A verification link has been sent to the new email address",
+		})
+	}
+}
+
+// DeleteAccount schedules the account for deletion after a grace period and
+// emails a link to download a final export of the user's data
+func DeleteAccount(db *sql.DB, cb *utils.CircuitBreaker, mailer utils.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		var req models.DeleteAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		if req.Password == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Password is required",
+			})
+			return
+		}
+
+		var email, passwordHash string
+		err := cb.Call(func() error {
+			return db.QueryRow("SELECT email, password_hash FROM users WHERE id = $1", userID).Scan(&email, &passwordHash)
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to query user",
+			})
+			return
+		}
+
+		if !utils.VerifyPassword(passwordHash, req.Password) {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_credentials",
+				Message: "Password is incorrect",
+			})
+			return
+		}
+
+		// Shared workspaces must keep an owner, as when leaving them
+		var soleOwned int
+		err = cb.Call(func() error {
+			return db.QueryRow(
+				`SELECT COUNT(*) FROM workspaces w
+				JOIN workspace_members m ON m.workspace_id = w.id AND m.user_id = $1 AND m.role = 'owner'
+				WHERE w.personal_user_id IS NULL
+				AND EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspace_id = w.id AND o.user_id <> $1)
+				AND NOT EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspace_id = w.id AND o.user_id <> $1 AND o.role = 'owner')`,
+				userID,
+			).Scan(&soleOwned)
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to schedule account deletion",
+			})
+			return
+		}
+
+		if soleOwned > 0 {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "last_owner",
+				Message: "Make another member an owner of each shared workspace you own before deleting your account",
+			})
+			return
+		}
+
+		grace := config.GetAccountDeletionGrace()
+		var scheduledAt time.Time
+		err = cb.Call(func() error {
+			return db.QueryRow(
+				"UPDATE users SET deletion_scheduled_at = COALESCE(deletion_scheduled_at, CURRENT_TIMESTAMP + $1 * INTERVAL '1 second'), updated_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING deletion_scheduled_at",
+				int(grace.Seconds()), userID,
+			).Scan(&scheduledAt)
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to schedule account deletion",
+			})
+			return
+		}
+
+		// The export link works once, until the account is gone. Only its hash is stored,
+		// and a new deletion request replaces it.
+		exportToken, err := utils.GenerateRandomToken(32)
+		if err == nil {
+			err = cb.Call(func() error {
+				tx, err := db.Begin()
+				if err != nil {
+					return err
+				}
+				defer tx.Rollback()
+
+				if _, err := tx.Exec("DELETE FROM account_export_tokens WHERE user_id = $1", userID); err != nil {
+					return err
+				}
+				if _, err := tx.Exec(
+					"INSERT INTO account_export_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+					userID, utils.HashToken(exportToken), scheduledAt,
+				); err != nil {
+					return err
+				}
+				return tx.Commit()
+			})
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to generate export link",
+			})
+			return
+		}
+		exportURL := fmt.Sprintf("%s/api/v1/account/export/download?token=%s", config.GetAPIBaseURL(), url.QueryEscape(exportToken))
+
+		go func() {
+			body := fmt.Sprintf(
+				"Your VicNotes account is scheduled for deletion on %s.\n\n"+
+					"Until then you can download a copy of your notes here:\n\n%s\n\n"+
+					"Changed your mind? Log in and cancel the deletion before that date.",
+				scheduledAt.Format("January 2, 2006 15:04 MST"), exportURL,
+			)
+			if err := mailer.Send(email, "Your VicNotes account will be deleted", body); err != nil {
+				log.Printf("Failed to send account deletion email: %v", err)
+			}
+		}()
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(models.DeleteAccountResponse{
+			DeletionScheduledAt: scheduledAt,
+			ExportURL:           exportURL,
+		})
+	}
+}
+
+// CancelAccountDeletion clears a scheduled deletion during the grace period
+// and revokes the export link sent with it
+func CancelAccountDeletion(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		err := cb.Call(func() error {
+			if _, err := db.Exec(
+				"UPDATE users SET deletion_scheduled_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1",
+				userID,
+			); err != nil {
+				return err
+			}
+			return revokeExportLinks(db, userID)
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to cancel account deletion",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Account deletion cancelled"})
+	}
+}
+
+// ExportAccount returns all of the caller's data as JSON
+func ExportAccount(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("user_id").(int)
+		writeAccountExport(w, db, cb, userID)
+	}
+}
+
+// DownloadAccountExport returns a user's data using the single-use link from the deletion email
+func DownloadAccountExport(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var userID int
+		err := cb.Call(func() error {
+			return db.QueryRow(
+				"UPDATE account_export_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP RETURNING user_id",
+				utils.HashToken(r.URL.Query().Get("token")),
+			).Scan(&userID)
+		})
+
+		if err == sql.ErrNoRows {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_token",
+				Message: "Export link is invalid, expired or already used",
+			})
+			return
+		}
+
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to export account",
+			})
+			return
+		}
+
+		writeAccountExport(w, db, cb, userID)
+	}
+}
+
+// revokeExportLinks deletes the export links of a deletion request, so they stop working
+func revokeExportLinks(db *sql.DB, userID int) error {
+	_, err := db.Exec("DELETE FROM account_export_tokens WHERE user_id = $1", userID)
+	return err
+}
+
+// writeAccountExport loads a user's data and writes it as a JSON attachment
+func writeAccountExport(w http.ResponseWriter, db *sql.DB, cb *utils.CircuitBreaker, userID int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	export := models.AccountExport{Notes: []models.Note{}}
+	err := cb.Call(func() error {
+		err := db.QueryRow(
+			"SELECT id, email, email_verified, totp_enabled, role, created_at, updated_at, deletion_scheduled_at FROM users WHERE id = $1",
+			userID,
+		).Scan(&export.User.ID, &export.User.Email, &export.User.EmailVerified, &export.User.TOTPEnabled, &export.User.Role,
+			&export.User.CreatedAt, &export.User.UpdatedAt, &export.User.DeletionScheduledAt)
+		if err != nil {
+			return err
+		}
+
+		rows, err := db.Query(
+			"SELECT "+noteColumns+" FROM notes n WHERE n.user_id = $1 ORDER BY n.created_at",
+			userID,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var note models.Note
+			if err := scanNote(rows, &note); err != nil {
+				return err
+			}
+			export.Notes = append(export.Notes, note)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		return attachTags(db, export.Notes)
+	})
+
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "not_found",
+			Message: "Account not found",
+		})
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to export account",
+		})
+		return
+	}
+
+	export.ExportedAt = time.Now().UTC()
+
+	w.Header().Set("Content-Disposition", `attachment; filename="vicnotes-export.json"`)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(export)
+}
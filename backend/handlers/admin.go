@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+const (
+	adminDefaultPerPage = 50
+	adminMaxPerPage     = 200
+)
+
+// adminUserColumns selects a user together with their note usage
+const adminUserColumns = `u.id, u.email, u.email_verified, u.totp_enabled, u.role, u.disabled_at,
+	u.password_reset_required, u.created_at, u.updated_at, u.deletion_scheduled_at,
+	COUNT(n.id), COALESCE(SUM(OCTET_LENGTH(n.title) + OCTET_LENGTH(COALESCE(n.content, ''))), 0)`
+
+// scanAdminUser scans a row selected with adminUserColumns
+func scanAdminUser(row interface{ Scan(...interface{}) error }) (models.AdminUser, error) {
+	var user models.AdminUser
+	err := row.Scan(
+		&user.ID, &user.Email, &user.EmailVerified, &user.TOTPEnabled, &user.Role, &user.DisabledAt,
+		&user.PasswordResetRequired, &user.CreatedAt, &user.UpdatedAt, &user.DeletionScheduledAt,
+		&user.NoteCount, &user.StorageBytes,
+	)
+	return user, err
+}
+
+// ListUsers lists users with their note counts and storage usage.
+// The optional q parameter searches emails.
+func ListUsers(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		page, perPage, ok := parsePagination(r, adminDefaultPerPage, adminMaxPerPage)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "page and per_page must be positive integers",
+			})
+			return
+		}
+
+		// An empty pattern matches every email
+		pattern := "%" + escapeLike(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))) + "%"
+
+		list := models.AdminUserList{Users: []models.AdminUser{}, Page: page, PerPage: perPage}
+		err := cb.Call(func() error {
+			if err := db.QueryRow(
+				"SELECT COUNT(*) FROM users WHERE LOWER(email) LIKE $1",
+				pattern,
+			).Scan(&list.Total); err != nil {
+				return err
+			}
+
+			rows, err := db.Query(
+				`SELECT `+adminUserColumns+`
+				FROM users u LEFT JOIN notes n ON n.user_id = u.id AND n.deleted_at IS NULL
+				WHERE LOWER(u.email) LIKE $1
+				GROUP BY u.id
+				ORDER BY u.id
+				LIMIT $2 OFFSET $3`,
+				pattern, perPage, (page-1)*perPage,
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			list.Users = []models.AdminUser{}
+			for rows.Next() {
+				user, err := scanAdminUser(rows)
+				if err != nil {
+					return err
+				}
+				list.Users = append(list.Users, user)
+			}
+			return rows.Err()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch users",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// GetUser returns a single user with their note count and storage usage
+func GetUser(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		targetID, ok := parseAdminUserID(w, r)
+		if !ok {
+			return
+		}
+
+		var user models.AdminUser
+		err := cb.Call(func() error {
+			var err error
+			user, err = scanAdminUser(db.QueryRow(
+				`SELECT `+adminUserColumns+`
+				FROM users u LEFT JOIN notes n ON n.user_id = u.id AND n.deleted_at IS NULL
+				WHERE u.id = $1
+				GROUP BY u.id`,
+				targetID,
+			))
+			return err
+		})
+
+		if err == sql.ErrNoRows {
+			writeUserNotFound(w)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch user",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(user)
+	}
+}
+
+// DisableUser blocks a user from logging in and rejects their existing tokens
+func DisableUser(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return setUserDisabled(db, cache, cb, true)
+}
+
+// EnableUser lifts a previous DisableUser
+func EnableUser(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return setUserDisabled(db, cache, cb, false)
+}
+
+func setUserDisabled(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker, disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		targetID, ok := parseAdminUserID(w, r)
+		if !ok {
+			return
+		}
+
+		if disabled && targetID == userID {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "You cannot disable your own account",
+			})
+			return
+		}
+
+		query := "UPDATE users SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP WHERE id = $1"
+		message := "User disabled successfully"
+		action := auditAdminUserDisable
+		if !disabled {
+			query = "UPDATE users SET disabled_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1"
+			message = "User enabled successfully"
+			action = auditAdminUserEnable
+		}
+
+		var affected int64
+		err := cb.Call(func() error {
+			result, err := db.Exec(query, targetID)
+			if err != nil {
+				return err
+			}
+			affected, err = result.RowsAffected()
+			return err
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to update user",
+			})
+			return
+		}
+
+		if affected == 0 {
+			writeUserNotFound(w)
+			return
+		}
+
+		cache.Delete(fmt.Sprintf("auth:%d", targetID))
+		recordAudit(db, cb, r, auditEvent{UserID: targetID, Action: action, TargetType: "user", TargetID: targetID})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": message})
+	}
+}
+
+// ForcePasswordReset signs a user out everywhere and makes them choose a new
+// password through the emailed reset link before they can log in again
+func ForcePasswordReset(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker, mailer utils.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		targetID, ok := parseAdminUserID(w, r)
+		if !ok {
+			return
+		}
+
+		var email string
+		err := cb.Call(func() error {
+			return db.QueryRow(
+				`UPDATE users SET password_reset_required = TRUE, token_version = token_version + 1, updated_at = CURRENT_TIMESTAMP
+				WHERE id = $1 RETURNING email`,
+				targetID,
+			).Scan(&email)
+		})
+
+		if err == sql.ErrNoRows {
+			writeUserNotFound(w)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to update user",
+			})
+			return
+		}
+
+		cache.Delete(fmt.Sprintf("auth:%d", targetID))
+		recordAudit(db, cb, r, auditEvent{UserID: targetID, Action: auditAdminPasswordReset, TargetType: "user", TargetID: targetID})
+		recordTokensRevoked(db, cb, r, targetID, auditAdminPasswordReset)
+
+		if err := sendPasswordResetEmail(db, cb, mailer, targetID, email, resetReasonForced); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to send password reset email",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Password reset required; a reset link has been emailed to the user"})
+	}
+}
+
+// UpdateUserRole changes a user's role
+func UpdateUserRole(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		targetID, ok := parseAdminUserID(w, r)
+		if !ok {
+			return
+		}
+
+		var req models.UpdateRoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		if req.Role != models.RoleUser && req.Role != models.RoleAdmin {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Role must be user or admin",
+			})
+			return
+		}
+
+		// Keep at least one admin able to manage the instance
+		if targetID == userID && req.Role != models.RoleAdmin {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "You cannot remove your own admin role",
+			})
+			return
+		}
+
+		var affected int64
+		err := cb.Call(func() error {
+			result, err := db.Exec(
+				"UPDATE users SET role = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+				req.Role, targetID,
+			)
+			if err != nil {
+				return err
+			}
+			affected, err = result.RowsAffected()
+			return err
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to update user",
+			})
+			return
+		}
+
+		if affected == 0 {
+			writeUserNotFound(w)
+			return
+		}
+
+		cache.Delete(fmt.Sprintf("auth:%d", targetID))
+		recordAudit(db, cb, r, auditEvent{
+			UserID:     targetID,
+			Action:     auditAdminRoleChange,
+			TargetType: "user",
+			TargetID:   targetID,
+			Details:    map[string]string{"role": req.Role},
+		})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Role updated successfully"})
+	}
+}
+
+// parseAdminUserID reads the {id} path variable, writing a 400 if it is invalid
+func parseAdminUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	targetID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Invalid user ID",
+		})
+		return 0, false
+	}
+	return targetID, true
+}
+
+func writeUserNotFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   "not_found",
+		Message: "User not found",
+	})
+}
+
+// parsePagination reads the page and per_page query parameters
+func parsePagination(r *http.Request, defaultPerPage, maxPerPage int) (page, perPage int, ok bool) {
+	page, perPage = 1, defaultPerPage
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		page = n
+	}
+
+	if v := r.URL.Query().Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		perPage = n
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	return page, perPage, true
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
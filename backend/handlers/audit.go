@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+// Audit log actions
+const (
+	auditLoginSuccess          = "login.success"
+	auditLoginFailure          = "login.failure"
+	auditRegister              = "user.register"
+	auditPasswordChange        = "user.password_change"
+	auditPasswordReset         = "user.password_reset"
+	auditNoteCreate            = "note.create"
+	auditNoteUpdate            = "note.update"
+	auditNoteDelete            = "note.delete"
+	auditNoteShare             = "note.share"
+	auditNoteUnshare           = "note.unshare"
+	auditNoteLinkCreate        = "note.link_create"
+	auditNoteLinkRevoke        = "note.link_revoke"
+	auditNoteRevisionRestore   = "note.revision_restore"
+	auditNoteRestore           = "note.restore"
+	auditNotePurge             = "note.purge"
+	auditNotebookDelete        = "notebook.delete"
+	auditAdminUserDisable      = "admin.user_disable"
+	auditAdminUserEnable       = "admin.user_enable"
+	auditAdminPasswordReset    = "admin.force_password_reset"
+	auditAdminRoleChange       = "admin.role_change"
+	auditAdminInviteCreate     = "admin.invite_create"
+	auditAdminInviteRevoke     = "admin.invite_revoke"
+	auditTokensRevoke          = "tokens.revoke"
+	auditWorkspaceCreate       = "workspace.create"
+	auditWorkspaceDelete       = "workspace.delete"
+	auditWorkspaceMemberAdd    = "workspace.member_add"
+	auditWorkspaceMemberUpdate = "workspace.member_update"
+	auditWorkspaceMemberRemove = "workspace.member_remove"
+)
+
+const (
+	auditDefaultPerPage = 50
+	auditMaxPerPage     = 200
+	auditMaxUserAgent   = 512
+	// auditMaxIPAddress is the size of audit_log.ip_address
+	auditMaxIPAddress = 64
+)
+
+// auditEvent describes an event to record. UserID is the account it concerns,
+// 0 if unknown; the actor is taken from the authenticated request when there is one.
+type auditEvent struct {
+	UserID     int
+	Action     string
+	TargetType string
+	TargetID   int
+	Details    map[string]string
+}
+
+// recordAudit appends an event to the audit log. Failures are logged and never
+// fail the request that triggered them.
+func recordAudit(db *sql.DB, cb *utils.CircuitBreaker, r *http.Request, event auditEvent) {
+	actorID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		actorID = event.UserID
+	}
+	requestID, _ := r.Context().Value("request_id").(string)
+
+	// Client-supplied values must never make the insert fail, or events could be suppressed
+	userAgent := auditText(r.UserAgent(), auditMaxUserAgent)
+	ip := auditText(utils.ClientIP(r), auditMaxIPAddress)
+
+	var details []byte
+	if len(event.Details) > 0 {
+		clean := make(map[string]string, len(event.Details))
+		for key, value := range event.Details {
+			clean[auditText(key, 0)] = auditText(value, 0)
+		}
+
+		var err error
+		if details, err = json.Marshal(clean); err != nil {
+			log.Printf("Failed to encode audit details for %s: %v", event.Action, err)
+		}
+	}
+
+	err := cb.Call(func() error {
+		_, err := db.Exec(
+			`INSERT INTO audit_log (user_id, actor_id, action, target_type, target_id, ip_address, user_agent, request_id, details)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			nullInt(event.UserID), nullInt(actorID), event.Action, nullString(event.TargetType), nullInt(event.TargetID),
+			ip, userAgent, requestID, nullBytes(details),
+		)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// auditText makes a string storable in Postgres: invalid UTF-8 and NUL bytes,
+// which it rejects, are dropped, and it is cut to at most max runes when max > 0
+func auditText(s string, max int) string {
+	s = strings.ReplaceAll(strings.ToValidUTF8(s, ""), "\x00", "")
+	if max > 0 {
+		if runes := []rune(s); len(runes) > max {
+			s = string(runes[:max])
+		}
+	}
+	return s
+}
+
+// recordTokensRevoked records that every token of userID was invalidated
+func recordTokensRevoked(db *sql.DB, cb *utils.CircuitBreaker, r *http.Request, userID int, reason string) {
+	recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditTokensRevoke, Details: map[string]string{"reason": reason}})
+}
+
+// ListAuditEvents lists the authenticated user's own audit events
+func ListAuditEvents(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("user_id").(int)
+		writeAuditEvents(w, r, db, cb, userID)
+	}
+}
+
+// ListAllAuditEvents lists every audit event, optionally filtered by ?user_id=
+func ListAllAuditEvents(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var userID int
+		if v := r.URL.Query().Get("user_id"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil || id < 1 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "invalid_request",
+					Message: "Invalid user ID",
+				})
+				return
+			}
+			userID = id
+		}
+		writeAuditEvents(w, r, db, cb, userID)
+	}
+}
+
+// writeAuditEvents writes one page of audit events, newest first.
+// userID restricts the events to one account when non-zero, and ?action=
+// filters by action, matching a prefix when it ends in a dot (e.g. "admin.").
+func writeAuditEvents(w http.ResponseWriter, r *http.Request, db *sql.DB, cb *utils.CircuitBreaker, userID int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page, perPage, ok := parsePagination(r, auditDefaultPerPage, auditMaxPerPage)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "page and per_page must be positive integers",
+		})
+		return
+	}
+
+	var conditions []string
+	var args []interface{}
+	if userID != 0 {
+		args = append(args, userID)
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	if action := strings.TrimSpace(r.URL.Query().Get("action")); action != "" {
+		if strings.HasSuffix(action, ".") {
+			args = append(args, escapeLike(action)+"%")
+			conditions = append(conditions, fmt.Sprintf("action LIKE $%d", len(args)))
+		} else {
+			args = append(args, action)
+			conditions = append(conditions, fmt.Sprintf("action = $%d", len(args)))
+		}
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	list := models.AuditEventList{Events: []models.AuditEvent{}, Page: page, PerPage: perPage}
+	err := cb.Call(func() error {
+		if err := db.QueryRow("SELECT COUNT(*) FROM audit_log "+where, args...).Scan(&list.Total); err != nil {
+			return err
+		}
+
+		pageArgs := append(args, perPage, (page-1)*perPage)
+		rows, err := db.Query(
+			fmt.Sprintf(
+				`SELECT id, user_id, actor_id, action, COALESCE(target_type, ''), target_id, COALESCE(ip_address, ''),
+				COALESCE(user_agent, ''), COALESCE(request_id, ''), details, created_at
+				FROM audit_log %s ORDER BY id DESC LIMIT $%d OFFSET $%d`,
+				where, len(args)+1, len(args)+2,
+			),
+			pageArgs...,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		list.Events = []models.AuditEvent{}
+		for rows.Next() {
+			var event models.AuditEvent
+			var details []byte
+			if err := rows.Scan(&event.ID, &event.UserID, &event.ActorID, &event.Action, &event.TargetType, &event.TargetID,
+				&event.IPAddress, &event.UserAgent, &event.RequestID, &details, &event.CreatedAt); err != nil {
+				return err
+			}
+			if len(details) > 0 {
+				if err := json.Unmarshal(details, &event.Details); err != nil {
+					return err
+				}
+			}
+			list.Events = append(list.Events, event)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to fetch audit events",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(list)
+}
+
+// nullInt maps 0 to NULL
+func nullInt(v int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(v), Valid: v != 0}
+}
+
+// nullString maps "" to NULL
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
+
+// nullBytes maps an empty slice to NULL
+func nullBytes(v []byte) interface{} {
+	if len(v) == 0 {
+		return nil
+	}
+	return string(v)
+}
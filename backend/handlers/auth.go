@@ -3,14 +3,16 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
 
+	"vicnotes/backend/config"
 	"vicnotes/backend/models"
 	"vicnotes/backend/utils"
 )
 
 // Register handles user registration
-func Register(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+func Register(db *sql.DB, cb *utils.CircuitBreaker, mailer utils.Mailer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
@@ -25,6 +27,7 @@ func Register(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
 		}
 
 		// Validate input
+		req.Email = utils.NormalizeEmail(req.Email)
 		if req.Email == "" || req.Password == "" {
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(models.ErrorResponse{
@@ -34,6 +37,30 @@ func Register(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
 			return
 		}
 
+		if !utils.ValidateEmail(req.Email) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_email",
+				Message: "Email address is not valid",
+			})
+			return
+		}
+
+		if policyErr := checkRegistrationPolicy(req.Email, req.InviteCode); policyErr != nil {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(policyErr)
+			return
+		}
+
+		if err := utils.ValidatePassword(req.Password, req.Email); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "weak_password",
+				Message: err.Error(),
+			})
+			return
+		}
+
 		// Hash password
 		passwordHash, err := utils.HashPassword(req.Password)
 		if err != nil {
@@ -48,12 +75,45 @@ func Register(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
 		// Insert user with circuit breaker
 		var userID int
 		err = cb.Call(func() error {
-			return db.QueryRow(
-				"INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id",
-				req.Email, passwordHash,
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			// Only use up the invite if the account is actually created
+			if config.GetRegistrationMode() == config.RegistrationInvite {
+				if err := consumeInviteCode(tx, req.InviteCode); err == sql.ErrNoRows {
+					return errInvalidInvite
+				} else if err != nil {
+					return err
+				}
+			}
+
+			err = tx.QueryRow(
+				"INSERT INTO users (email, password_hash, role) VALUES ($1, $2, $3) RETURNING id",
+				req.Email, passwordHash, roleForNewUser(req.Email),
 			).Scan(&userID)
+			if err != nil {
+				return err
+			}
+
+			if err := createPersonalWorkspace(tx, userID); err != nil {
+				return err
+			}
+
+			return tx.Commit()
 		})
 
+		if err == errInvalidInvite {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_invite_code",
+				Message: "Invite code is invalid, expired or used up",
+			})
+			return
+		}
+
 		if err != nil {
 			w.WriteHeader(http.StatusConflict)
 			json.NewEncoder(w).Encode(models.ErrorResponse{
@@ -63,8 +123,28 @@ func Register(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
 			return
 		}
 
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditRegister, Details: map[string]string{"method": "password"}})
+
+		// The account exists either way; the user can ask for a new link if this fails
+		if err := sendVerificationEmail(db, cb, mailer, userID, req.Email, verifyPurposeRegister); err != nil {
+			log.Printf("Failed to start email verification for user %d: %v", userID, err)
+		}
+
+		user := models.User{
+			ID:    userID,
+			Email: req.Email,
+			Role:  roleForNewUser(req.Email),
+		}
+
+		// Unverified accounts can't sign in, so don't hand out a token yet
+		if config.RequireEmailVerification() {
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(models.AuthResponse{User: user})
+			return
+		}
+
 		// Generate token
-		token, err := utils.GenerateToken(userID, req.Email)
+		token, err := utils.GenerateToken(userID, req.Email, 0)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(models.ErrorResponse{
@@ -74,19 +154,18 @@ func Register(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
 			return
 		}
 
+		token = deliverToken(w, token)
+
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(models.AuthResponse{
 			Token: token,
-			User: models.User{
-				ID:    userID,
-				Email: req.Email,
-			},
+			User:  user,
 		})
 	}
 }
 
 // Login handles user login
-func Login(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+func Login(db *sql.DB, cb *utils.CircuitBreaker, accountLimiter, ipLimiter *utils.LoginLimiter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
@@ -101,6 +180,7 @@ func Login(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
 		}
 
 		// Validate input
+		req.Email = utils.NormalizeEmail(req.Email)
 		if req.Email == "" || req.Password == "" {
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(models.ErrorResponse{
@@ -110,17 +190,33 @@ func Login(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
 			return
 		}
 
+		ip := utils.ClientIP(r)
+		if wait := loginRetryAfter(accountLimiter, ipLimiter, req.Email, ip); wait > 0 {
+			writeTooManyAttempts(w, wait)
+			return
+		}
+
 		// Get user with circuit breaker
 		var user models.User
 		var passwordHash string
+		var tokenVersion int
+		var resetRequired bool
 		err := cb.Call(func() error {
 			return db.QueryRow(
-				"SELECT id, email, password_hash FROM users WHERE email = $1",
+				"SELECT id, email, email_verified, totp_enabled, role, disabled_at, deletion_scheduled_at, password_hash, token_version, password_reset_required FROM users WHERE LOWER(email) = $1",
 				req.Email,
-			).Scan(&user.ID, &user.Email, &passwordHash)
+			).Scan(&user.ID, &user.Email, &user.EmailVerified, &user.TOTPEnabled, &user.Role, &user.DisabledAt, &user.DeletionScheduledAt, &passwordHash, &tokenVersion, &resetRequired)
 		})
 
 		if err == sql.ErrNoRows {
+			// Spend the same time as a real check so unknown emails can't be told apart
+			utils.VerifyDummyPassword(req.Password)
+			recordLoginFailure(db, cb, accountLimiter, ipLimiter, req.Email, ip)
+			recordAudit(db, cb, r, auditEvent{
+				Action:  auditLoginFailure,
+				Details: map[string]string{"method": "password", "reason": "unknown_email", "email": req.Email},
+			})
+
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(models.ErrorResponse{
 				Error:   "invalid_credentials",
@@ -140,6 +236,13 @@ func Login(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
 
 		// Verify password
 		if !utils.VerifyPassword(passwordHash, req.Password) {
+			recordLoginFailure(db, cb, accountLimiter, ipLimiter, req.Email, ip)
+			recordAudit(db, cb, r, auditEvent{
+				UserID:  user.ID,
+				Action:  auditLoginFailure,
+				Details: map[string]string{"method": "password", "reason": "invalid_password"},
+			})
+
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(models.ErrorResponse{
 				Error:   "invalid_credentials",
@@ -148,21 +251,123 @@ func Login(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
 			return
 		}
 
-		// Generate token
-		token, err := utils.GenerateToken(user.ID, user.Email)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(models.ErrorResponse{
-				Error:   "server_error",
-				Message: "Failed to generate token",
+		// Upgrade hashes made with an outdated algorithm or cost while we have the plaintext
+		if utils.NeedsRehash(passwordHash) {
+			if newHash, err := utils.HashPassword(req.Password); err != nil {
+				log.Printf("Failed to rehash password for user %d: %v", user.ID, err)
+			} else if err := cb.Call(func() error {
+				_, err := db.Exec("UPDATE users SET password_hash = $1 WHERE id = $2", newHash, user.ID)
+				return err
+			}); err != nil {
+				log.Printf("Failed to store rehashed password for user %d: %v", user.ID, err)
+			}
+		}
+
+		if user.DisabledAt != nil {
+			recordAudit(db, cb, r, auditEvent{
+				UserID:  user.ID,
+				Action:  auditLoginFailure,
+				Details: map[string]string{"method": "password", "reason": "account_disabled"},
 			})
-			return
 		}
 
+		if resetRequired && user.DisabledAt == nil {
+			recordAudit(db, cb, r, auditEvent{
+				UserID:  user.ID,
+				Action:  auditLoginFailure,
+				Details: map[string]string{"method": "password", "reason": "password_reset_required"},
+			})
+		}
+
+		if writeLoginResponse(w, user, tokenVersion, resetRequired) {
+			accountLimiter.Success("email:" + req.Email)
+			recordAudit(db, cb, r, auditEvent{UserID: user.ID, Action: auditLoginSuccess, Details: map[string]string{"method": "password"}})
+		}
+	}
+}
+
+// writeLoginResponse finishes a login once the user has proven who they are.
+// It refuses accounts that can't log in, asks for a second factor when TOTP is
+// enabled, and otherwise issues a token. It reports whether a token was issued.
+func writeLoginResponse(w http.ResponseWriter, user models.User, tokenVersion int, resetRequired bool) bool {
+	if refusal := refuseLogin(user, resetRequired); refusal != nil {
+		w.WriteHeader(refusal.status)
+		json.NewEncoder(w).Encode(refusal.ErrorResponse)
+		return false
+	}
+
+	token, mfaToken, err := issueLogin(user, tokenVersion)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to generate token",
+		})
+		return false
+	}
+
+	// The first factor is done but a second one is still needed
+	if mfaToken != "" {
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(models.AuthResponse{
-			Token: token,
-			User:  user,
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			User:        user,
 		})
+		return false
+	}
+
+	token = deliverToken(w, token)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.AuthResponse{
+		Token: token,
+		User:  user,
+	})
+	return true
+}
+
+// loginRefusal is why an account that proved who it is still can't log in
+type loginRefusal struct {
+	status int
+	models.ErrorResponse
+}
+
+// refuseLogin checks an account may log in: it must not be disabled or waiting
+// for a forced password reset, and its email must be verified when that is required
+func refuseLogin(user models.User, resetRequired bool) *loginRefusal {
+	if user.DisabledAt != nil {
+		return &loginRefusal{http.StatusForbidden, models.ErrorResponse{
+			Error:   "account_disabled",
+			Message: "This account has been disabled",
+		}}
+	}
+
+	if resetRequired {
+		return &loginRefusal{http.StatusForbidden, models.ErrorResponse{
+			Error:   "password_reset_required",
+			Message: "You must choose a new password, check your email for a reset link",
+		}}
+	}
+
+	if config.RequireEmailVerification() && !user.EmailVerified {
+		return &loginRefusal{http.StatusForbidden, models.ErrorResponse{
+			Error:   "email_not_verified",
+			Message: "Please verify your email address before logging in",
+		}}
 	}
+
+	return nil
+}
+
+// issueLogin issues a session token, or an MFA token when TOTP is enabled and
+// the user still has to complete the login at /auth/login/2fa
+func issueLogin(user models.User, tokenVersion int) (token, mfaToken string, err error) {
+	if user.TOTPEnabled {
+		mfaToken, err = utils.GeneratePurposeToken(user.ID, user.Email, tokenVersion, utils.PurposeMFA, mfaTokenTTL)
+		return "", mfaToken, err
+	}
+
+	token, err = utils.GenerateToken(user.ID, user.Email, tokenVersion)
+	return token, "", err
 }
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+// CreateInvite generates a new registration invite code
+func CreateInvite(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		var req models.CreateInviteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		if req.MaxUses == 0 {
+			req.MaxUses = 1
+		}
+		if req.MaxUses < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "max_uses must be positive",
+			})
+			return
+		}
+
+		var expiresIn time.Duration
+		if req.ExpiresIn != "" {
+			d, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || d <= 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "validation_error",
+					Message: "expires_in must be a positive duration such as 72h",
+				})
+				return
+			}
+			expiresIn = d
+		}
+
+		code, err := utils.GenerateRandomToken(12)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to generate invite code",
+			})
+			return
+		}
+
+		invite := models.InviteCode{
+			Code:      code,
+			CodeHint:  code[:4],
+			MaxUses:   req.MaxUses,
+			CreatedBy: &userID,
+		}
+
+		// A NULL interval leaves expires_at NULL, meaning the invite never expires
+		var expiresSeconds sql.NullInt64
+		if expiresIn > 0 {
+			expiresSeconds = sql.NullInt64{Int64: int64(expiresIn.Seconds()), Valid: true}
+		}
+
+		err = cb.Call(func() error {
+			return db.QueryRow(
+				"INSERT INTO invite_codes (code_hash, code_hint, max_uses, expires_at, created_by) VALUES ($1, $2, $3, CURRENT_TIMESTAMP + $4 * INTERVAL '1 second', $5) RETURNING id, expires_at, created_at",
+				utils.HashToken(code), invite.CodeHint, invite.MaxUses, expiresSeconds, userID,
+			).Scan(&invite.ID, &invite.ExpiresAt, &invite.CreatedAt)
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to create invite",
+			})
+			return
+		}
+
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditAdminInviteCreate, TargetType: "invite", TargetID: invite.ID})
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(invite)
+	}
+}
+
+// ListInvites lists every invite code, newest first
+func ListInvites(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var invites []models.InviteCode
+		err := cb.Call(func() error {
+			rows, err := db.Query(
+				"SELECT id, code_hint, max_uses, use_count, expires_at, revoked_at, created_by, created_at FROM invite_codes ORDER BY created_at DESC",
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			invites = []models.InviteCode{}
+			for rows.Next() {
+				var invite models.InviteCode
+				if err := rows.Scan(&invite.ID, &invite.CodeHint, &invite.MaxUses, &invite.UseCount, &invite.ExpiresAt, &invite.RevokedAt, &invite.CreatedBy, &invite.CreatedAt); err != nil {
+					return err
+				}
+				invites = append(invites, invite)
+			}
+			return rows.Err()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch invites",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(invites)
+	}
+}
+
+// RevokeInvite stops an invite code from being used
+func RevokeInvite(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		vars := mux.Vars(r)
+		inviteID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Invalid invite ID",
+			})
+			return
+		}
+
+		var affected int64
+		err = cb.Call(func() error {
+			result, err := db.Exec(
+				"UPDATE invite_codes SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE id = $1",
+				inviteID,
+			)
+			if err != nil {
+				return err
+			}
+			affected, err = result.RowsAffected()
+			return err
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to revoke invite",
+			})
+			return
+		}
+
+		if affected == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "not_found",
+				Message: "Invite not found",
+			})
+			return
+		}
+
+		recordAudit(db, cb, r, auditEvent{
+			UserID:     r.Context().Value("user_id").(int),
+			Action:     auditAdminInviteRevoke,
+			TargetType: "invite",
+			TargetID:   inviteID,
+		})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Invite revoked successfully"})
+	}
+}
+
+// consumeInviteCode uses up one registration from an invite code inside tx
+func consumeInviteCode(tx *sql.Tx, code string) error {
+	var inviteID int
+	return tx.QueryRow(
+		`UPDATE invite_codes SET use_count = use_count + 1
+		WHERE code_hash = $1 AND revoked_at IS NULL AND use_count < max_uses
+		AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		RETURNING id`,
+		utils.HashToken(strings.TrimSpace(code)),
+	).Scan(&inviteID)
+}
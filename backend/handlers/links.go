@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"vicnotes/backend/config"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+// publicNoteTemplate renders a shared note. html/template escapes the title
+// and content, so notes can't inject markup or scripts.
+var publicNoteTemplate = template.Must(template.New("note").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>body{max-width:42rem;margin:2rem auto;padding:0 1rem;font-family:sans-serif;line-height:1.5}</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Paragraphs}}<p>{{range $i, $line := .}}{{if $i}}<br>{{end}}{{$line}}{{end}}</p>
+{{end}}</body>
+</html>
+`))
+
+// CreateNoteLink creates a public read-only link to a note, optionally expiring
+// and protected by a password. Only the note's owner and workspace owners can create links.
+func CreateNoteLink(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok || !requireShareManager(w, access, userID) {
+			return
+		}
+
+		var req models.CreateNoteLinkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		var expiresIn time.Duration
+		if req.ExpiresIn != "" {
+			d, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || d <= 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "validation_error",
+					Message: "expires_in must be a positive duration such as 72h",
+				})
+				return
+			}
+			expiresIn = d
+		}
+
+		var passwordHash sql.NullString
+		if req.Password != "" {
+			hash, err := utils.HashPassword(req.Password)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "server_error",
+					Message: "Failed to hash password",
+				})
+				return
+			}
+			passwordHash = sql.NullString{String: hash, Valid: true}
+		}
+
+		token, err := utils.GenerateRandomToken(32)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to generate link",
+			})
+			return
+		}
+
+		link := models.NoteLink{
+			Token:       token,
+			URL:         config.GetAPIBaseURL() + "/api/v1/public/notes/" + token,
+			TokenHint:   token[:4],
+			HasPassword: passwordHash.Valid,
+			CreatedBy:   &userID,
+		}
+
+		// A NULL interval leaves expires_at NULL, meaning the link never expires
+		var expiresSeconds sql.NullInt64
+		if expiresIn > 0 {
+			expiresSeconds = sql.NullInt64{Int64: int64(expiresIn.Seconds()), Valid: true}
+		}
+
+		err = cb.Call(func() error {
+			return db.QueryRow(
+				`INSERT INTO note_links (note_id, token_hash, token_hint, password_hash, expires_at, created_by)
+				VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second', $6) RETURNING id, expires_at, created_at`,
+				noteID, utils.HashToken(token), link.TokenHint, passwordHash, expiresSeconds, userID,
+			).Scan(&link.ID, &link.ExpiresAt, &link.CreatedAt)
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to create link",
+			})
+			return
+		}
+
+		recordAudit(db, cb, r, auditEvent{
+			UserID:     userID,
+			Action:     auditNoteLinkCreate,
+			TargetType: "note",
+			TargetID:   noteID,
+			Details:    map[string]string{"link_id": strconv.Itoa(link.ID)},
+		})
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(link)
+	}
+}
+
+// ListNoteLinks lists a note's public links, newest first
+func ListNoteLinks(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok || !requireShareManager(w, access, userID) {
+			return
+		}
+
+		var links []models.NoteLink
+		err := cb.Call(func() error {
+			rows, err := db.Query(
+				`SELECT id, token_hint, password_hash IS NOT NULL, expires_at, revoked_at, created_by, created_at
+				FROM note_links WHERE note_id = $1 ORDER BY created_at DESC`,
+				noteID,
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			links = []models.NoteLink{}
+			for rows.Next() {
+				var link models.NoteLink
+				if err := rows.Scan(&link.ID, &link.TokenHint, &link.HasPassword, &link.ExpiresAt, &link.RevokedAt, &link.CreatedBy, &link.CreatedAt); err != nil {
+					return err
+				}
+				links = append(links, link)
+			}
+			return rows.Err()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch links",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(links)
+	}
+}
+
+// RevokeNoteLink stops a public link from working
+func RevokeNoteLink(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		linkID, err := strconv.Atoi(mux.Vars(r)["link_id"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Invalid link ID",
+			})
+			return
+		}
+
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok || !requireShareManager(w, access, userID) {
+			return
+		}
+
+		var affected int64
+		err = cb.Call(func() error {
+			result, err := db.Exec(
+				"UPDATE note_links SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE id = $1 AND note_id = $2",
+				linkID, noteID,
+			)
+			if err != nil {
+				return err
+			}
+			affected, err = result.RowsAffected()
+			return err
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to revoke link",
+			})
+			return
+		}
+
+		if affected == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "not_found",
+				Message: "Link not found",
+			})
+			return
+		}
+
+		recordAudit(db, cb, r, auditEvent{
+			UserID:     userID,
+			Action:     auditNoteLinkRevoke,
+			TargetType: "note",
+			TargetID:   noteID,
+			Details:    map[string]string{"link_id": strconv.Itoa(linkID)},
+		})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Link revoked successfully"})
+	}
+}
+
+// GetPublicNote serves a note through a public link without authentication.
+// The format is picked with ?format=json|text|html, or else from the Accept header.
+// Password-protected links need the password in the X-Share-Password header.
+func GetPublicNote(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// The token is in the URL, so keep it out of caches and Referer headers
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		format := publicNoteFormat(r)
+		if format == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "format must be json, text or html",
+			})
+			return
+		}
+
+		var note models.PublicNote
+		var passwordHash sql.NullString
+		err := cb.Call(func() error {
+			return db.QueryRow(
+				`SELECT n.title, n.content, n.updated_at, l.password_hash FROM note_links l
+				JOIN notes n ON n.id = l.note_id
+				WHERE l.token_hash = $1 AND l.revoked_at IS NULL AND n.deleted_at IS NULL
+				AND (l.expires_at IS NULL OR l.expires_at > CURRENT_TIMESTAMP)`,
+				utils.HashToken(mux.Vars(r)["token"]),
+			).Scan(&note.Title, &note.Content, &note.UpdatedAt, &passwordHash)
+		})
+
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "not_found",
+				Message: "Link not found or expired",
+			})
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch note",
+			})
+			return
+		}
+
+		if passwordHash.Valid {
+			password := r.Header.Get("X-Share-Password")
+			if password == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "password_required",
+					Message: "This link is protected by a password",
+				})
+				return
+			}
+			if !utils.VerifyPassword(passwordHash.String, password) {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "invalid_password",
+					Message: "Invalid password",
+				})
+				return
+			}
+		}
+
+		switch format {
+		case "text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(note.Title + "\n\n" + note.Content))
+		case "html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+			w.WriteHeader(http.StatusOK)
+			publicNoteTemplate.Execute(w, struct {
+				Title      string
+				Paragraphs [][]string
+			}{note.Title, noteParagraphs(note.Content)})
+		default:
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(note)
+		}
+	}
+}
+
+// publicNoteFormat returns the requested format of a public note, or "" if it is unknown
+func publicNoteFormat(r *http.Request) string {
+	switch format := r.URL.Query().Get("format"); format {
+	case "json", "text", "html":
+		return format
+	case "":
+	default:
+		return ""
+	}
+
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "text/html"):
+		return "html"
+	case strings.Contains(accept, "text/plain"):
+		return "text"
+	default:
+		return "json"
+	}
+}
+
+// noteParagraphs splits note content into paragraphs at blank lines, and paragraphs into lines
+func noteParagraphs(content string) [][]string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+
+	var paragraphs [][]string
+	for _, block := range strings.Split(content, "\n\n") {
+		if block = strings.Trim(block, "\n"); block != "" {
+			paragraphs = append(paragraphs, strings.Split(block, "\n"))
+		}
+	}
+	return paragraphs
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"vicnotes/backend/config"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+// loginRetryAfter returns how long the caller must wait before trying to log in again
+func loginRetryAfter(accountLimiter, ipLimiter *utils.LoginLimiter, email, ip string) time.Duration {
+	wait := accountLimiter.Check("email:" + email)
+	if ipWait := ipLimiter.Check("ip:" + ip); ipWait > wait {
+		wait = ipWait
+	}
+	return wait
+}
+
+// recordLoginFailure counts a failed attempt against the account and the source IP,
+// storing an audit record when either becomes locked out
+func recordLoginFailure(db *sql.DB, cb *utils.CircuitBreaker, accountLimiter, ipLimiter *utils.LoginLimiter, email, ip string) {
+	if failures, locked := accountLimiter.Failure("email:" + email); locked {
+		recordLockout(db, cb, "account", email, ip, failures)
+	}
+	if failures, locked := ipLimiter.Failure("ip:" + ip); locked {
+		recordLockout(db, cb, "ip", ip, ip, failures)
+	}
+}
+
+// recordLockout stores an audit record of a lockout
+func recordLockout(db *sql.DB, cb *utils.CircuitBreaker, scope, identifier, ip string, failures int) {
+	duration := config.GetLoginLockoutDuration()
+	log.Printf("Login lockout: scope=%s identifier=%s ip=%s failures=%d duration=%s", scope, identifier, ip, failures, duration)
+
+	err := cb.Call(func() error {
+		_, err := db.Exec(
+			"INSERT INTO login_lockouts (scope, identifier, ip_address, failures, locked_until) VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second')",
+			scope, identifier, ip, failures, int(duration.Seconds()),
+		)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to record login lockout: %v", err)
+	}
+}
+
+// writeTooManyAttempts responds with 429 and a Retry-After header
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   "too_many_attempts",
+		Message: "Too many failed login attempts, please try again later",
+	})
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"vicnotes/backend/config"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+// RequestMagicLink emails a single-use login link.
+// The response is the same whether or not the email is registered.
+func RequestMagicLink(db *sql.DB, cb *utils.CircuitBreaker, mailer utils.Mailer, limiter *utils.LoginLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req models.MagicLinkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		req.Email = utils.NormalizeEmail(req.Email)
+		if req.Email == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Email is required",
+			})
+			return
+		}
+
+		// Limit per email, whether or not it exists, so the limit reveals nothing
+		limiterKey := "magic:" + req.Email
+		if wait := limiter.Check(limiterKey); wait > 0 {
+			writeTooManyAttempts(w, wait)
+			return
+		}
+		limiter.Failure(limiterKey)
+
+		var userID int
+		err := cb.Call(func() error {
+			return db.QueryRow("SELECT id FROM users WHERE LOWER(email) = $1", req.Email).Scan(&userID)
+		})
+
+		if err != nil && err != sql.ErrNoRows {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to query user",
+			})
+			return
+		}
+
+		if err == nil {
+			token, err := utils.GenerateRandomToken(32)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "server_error",
+					Message: "Failed to generate login link",
+				})
+				return
+			}
+
+			ttl := config.GetMagicLinkTTL()
+			err = cb.Call(func() error {
+				_, err := db.Exec(
+					"INSERT INTO magic_link_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second')",
+					userID, utils.HashToken(token), int(ttl.Seconds()),
+				)
+				return err
+			})
+
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "server_error",
+					Message: "Failed to create login link",
+				})
+				return
+			}
+
+			link := fmt.Sprintf("%s/magic-link?token=%s", config.GetAppBaseURL(), token)
+			body := fmt.Sprintf(
+				"Use the link below to log in to VicNotes. It expires in %s and can only be used once.\n\n%s\n\n"+
+					"If you didn't request this, you can ignore this email.",
+				ttl, link,
+			)
+
+			// Send in the background so response time doesn't reveal whether the account exists
+			go func(email string) {
+				if err := mailer.Send(email, "Your VicNotes login link", body); err != nil {
+					log.Printf("Failed to send magic link email: %v", err)
+				}
+			}(req.Email)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "If an account exists for this email, a login link has been sent",
+		})
+	}
+}
+
+// MagicLinkLogin exchanges a magic link token for the same response Login returns
+func MagicLinkLogin(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req models.MagicLinkLoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		if req.Token == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Token is required",
+			})
+			return
+		}
+
+		var user models.User
+		var tokenVersion int
+		var resetRequired bool
+		err := cb.Call(func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			var userID int
+			err = tx.QueryRow(
+				"UPDATE magic_link_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP RETURNING user_id",
+				utils.HashToken(req.Token),
+			).Scan(&userID)
+			if err != nil {
+				return err
+			}
+
+			// Following the link proves the user controls the address
+			err = tx.QueryRow(
+				"UPDATE users SET email_verified = TRUE WHERE id = $1 RETURNING id, email, email_verified, totp_enabled, role, disabled_at, deletion_scheduled_at, token_version, password_reset_required",
+				userID,
+			).Scan(&user.ID, &user.Email, &user.EmailVerified, &user.TOTPEnabled, &user.Role, &user.DisabledAt, &user.DeletionScheduledAt, &tokenVersion, &resetRequired)
+			if err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		})
+
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_token",
+				Message: "Login link is invalid or has expired",
+			})
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to log in",
+			})
+			return
+		}
+
+		if writeLoginResponse(w, user, tokenVersion, resetRequired) {
+			recordAudit(db, cb, r, auditEvent{UserID: user.ID, Action: auditLoginSuccess, Details: map[string]string{"method": "magic_link"}})
+		}
+	}
+}
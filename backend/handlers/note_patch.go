@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+	"vicnotes/backend/models"
+	"vicnotes/backend/patch"
+	"vicnotes/backend/utils"
+)
+
+// Media types accepted by PatchNote
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// notePatch is the result of a patch: the fields of a note a patch can change
+type notePatch struct {
+	Title   string
+	Content string
+	Pinned  bool
+	Tags    []string
+}
+
+// PatchNote partially updates a note. The body is a JSON Merge Patch (RFC 7396),
+// sent as application/merge-patch+json or application/json, or a JSON Patch
+// (RFC 6902) sent as application/json-patch+json. Patches apply to the document
+//
+//	{"title": "...", "content": "...", "pinned": false, "tags": ["work"]}
+//
+// where tags are the names of existing workspace tags. Fields a patch removes
+// are cleared, except title which is required.
+func PatchNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		mediaType := mergePatchType
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			mediaType, _, _ = mime.ParseMediaType(contentType)
+		}
+		if mediaType != mergePatchType && mediaType != jsonPatchType && mediaType != "application/json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "unsupported_media_type",
+				Message: fmt.Sprintf("Content-Type must be %s or %s", mergePatchType, jsonPatchType),
+			})
+			return
+		}
+
+		var operations []patch.Operation
+		var mergePatch interface{}
+		body := json.NewDecoder(r.Body)
+		var err error
+		if mediaType == jsonPatchType {
+			err = body.Decode(&operations)
+		} else {
+			err = body.Decode(&mergePatch)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok {
+			return
+		}
+
+		if !requireRole(w, access.role(), models.WorkspaceEditor, "You don't have permission to update this note") {
+			return
+		}
+
+		versions, ok := parseIfMatch(w, r)
+		if !ok {
+			return
+		}
+
+		var current models.Note
+		err = cb.Call(func() error {
+			current, err = loadNote(db, noteID)
+			return err
+		})
+
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "not_found",
+				Message: "Note not found",
+			})
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to update note",
+			})
+			return
+		}
+
+		if versions != nil && !containsVersion(versions, current.Version) {
+			writeVersionConflict(w, db, cb, noteID)
+			return
+		}
+
+		// Apply the patch to the note's current fields
+		doc := noteDocument(current)
+		if mediaType == jsonPatchType {
+			doc, err = patch.Apply(doc, operations)
+		} else {
+			doc = patch.Merge(doc, mergePatch)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_patch",
+				Message: err.Error(),
+			})
+			return
+		}
+
+		updated, problem := readNotePatch(doc)
+		if problem != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: problem,
+			})
+			return
+		}
+
+		if !validNoteTitle(w, updated.Title) {
+			return
+		}
+
+		// Tagging needs the editor role in the note's workspace, as with TagNote
+		tagsChanged := !sameTagNames(current.Tags, updated.Tags)
+		if tagsChanged && !requireRole(w, access.WorkspaceRole, models.WorkspaceEditor, "You don't have permission to tag this note") {
+			return
+		}
+
+		var tagIDs []int64
+		var unknownTag string
+		var version int
+		err = cb.Call(func() error {
+			if tagsChanged {
+				var err error
+				if tagIDs, unknownTag, err = findTagIDs(db, access.WorkspaceID, updated.Tags); err != nil || unknownTag != "" {
+					return err
+				}
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			// Only the version the patch was applied to may be overwritten
+			if err := tx.QueryRow(
+				`UPDATE notes SET title = $1, content = $2, pinned = $3, version = version + 1, updated_at = CURRENT_TIMESTAMP
+				WHERE id = $4 AND deleted_at IS NULL AND version = $5 RETURNING version`,
+				updated.Title, updated.Content, updated.Pinned, noteID, current.Version,
+			).Scan(&version); err != nil {
+				return err
+			}
+
+			if tagsChanged {
+				if _, err := tx.Exec("DELETE FROM note_tags WHERE note_id = $1 AND NOT (tag_id = ANY($2))", noteID, pq.Array(tagIDs)); err != nil {
+					return err
+				}
+				if _, err := tx.Exec(
+					"INSERT INTO note_tags (note_id, tag_id) SELECT $1, UNNEST($2::int[]) ON CONFLICT DO NOTHING",
+					noteID, pq.Array(tagIDs),
+				); err != nil {
+					return err
+				}
+			}
+
+			if err := saveRevision(tx, noteID, userID); err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		})
+
+		if err == sql.ErrNoRows {
+			writeVersionConflict(w, db, cb, noteID)
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to update note",
+			})
+			return
+		}
+
+		if unknownTag != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: fmt.Sprintf("Tag %q doesn't exist in this workspace", unknownTag),
+			})
+			return
+		}
+
+		cache.Delete(fmt.Sprintf("note:%d", noteID))
+		cache.Delete(notesCacheKey(access.WorkspaceID))
+
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditNoteUpdate, TargetType: "note", TargetID: noteID})
+
+		var note models.Note
+		err = cb.Call(func() error {
+			note, err = loadNote(db, noteID)
+			return err
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch note",
+			})
+			return
+		}
+
+		w.Header().Set("ETag", noteETag(note.Version))
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(note)
+	}
+}
+
+// loadNote loads a note with its tags, without any access check
+func loadNote(db *sql.DB, noteID int) (models.Note, error) {
+	var note models.Note
+	if err := scanNote(db.QueryRow("SELECT "+noteColumns+" FROM notes n WHERE n.id = $1", noteID), &note); err != nil {
+		return note, err
+	}
+
+	notes := []models.Note{note}
+	if err := attachTags(db, notes); err != nil {
+		return note, err
+	}
+	return notes[0], nil
+}
+
+// noteDocument is the JSON document of a note that patches apply to
+func noteDocument(note models.Note) interface{} {
+	tags := make([]interface{}, len(note.Tags))
+	for i, tag := range note.Tags {
+		tags[i] = tag.Name
+	}
+	return map[string]interface{}{
+		"title":   note.Title,
+		"content": note.Content,
+		"pinned":  note.Pinned,
+		"tags":    tags,
+	}
+}
+
+// readNotePatch reads a patched note document, describing the problem if its fields are invalid
+func readNotePatch(doc interface{}) (notePatch, string) {
+	var result notePatch
+	fields, ok := doc.(map[string]interface{})
+	if !ok {
+		return result, "The patched note must be an object"
+	}
+
+	for name, value := range fields {
+		var ok bool
+		switch name {
+		case "title":
+			result.Title, ok = value.(string)
+		case "content":
+			result.Content, ok = value.(string)
+		case "pinned":
+			result.Pinned, ok = value.(bool)
+		case "tags":
+			var tags []interface{}
+			if tags, ok = value.([]interface{}); ok {
+				result.Tags, ok = tagNames(tags)
+			}
+		default:
+			return result, fmt.Sprintf("Unknown field %q; title, content, pinned and tags can be patched", name)
+		}
+		if !ok {
+			return result, fmt.Sprintf("Invalid value for %s", name)
+		}
+	}
+	return result, ""
+}
+
+// tagNames reads a list of tag names, dropping duplicates
+func tagNames(values []interface{}) ([]string, bool) {
+	names := []string{}
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		name, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		name = strings.TrimSpace(name)
+		if key := strings.ToLower(name); !seen[key] {
+			seen[key] = true
+			names = append(names, name)
+		}
+	}
+	return names, true
+}
+
+// sameTagNames reports whether a note's tags are the named ones, ignoring case
+func sameTagNames(tags []models.Tag, names []string) bool {
+	if len(tags) != len(names) {
+		return false
+	}
+	current := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		current[strings.ToLower(tag.Name)] = true
+	}
+	for _, name := range names {
+		if !current[strings.ToLower(name)] {
+			return false
+		}
+	}
+	return true
+}
+
+// findTagIDs looks up the IDs of named workspace tags. It returns the first
+// name without a tag, if any.
+func findTagIDs(db *sql.DB, workspaceID int, names []string) ([]int64, string, error) {
+	lowered := make([]string, len(names))
+	for i, name := range names {
+		lowered[i] = strings.ToLower(name)
+	}
+
+	rows, err := db.Query("SELECT id, LOWER(name) FROM tags WHERE workspace_id = $1 AND LOWER(name) = ANY($2)", workspaceID, pq.Array(lowered))
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	found := make(map[string]bool, len(names))
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, "", err
+		}
+		ids = append(ids, id)
+		found[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	for i, name := range lowered {
+		if !found[name] {
+			return nil, names[i], nil
+		}
+	}
+	return ids, "", nil
+}
+
+// containsVersion reports whether version is one of versions
+func containsVersion(versions []int64, version int) bool {
+	for _, v := range versions {
+		if v == int64(version) {
+			return true
+		}
+	}
+	return false
+}
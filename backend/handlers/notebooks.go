@@ -0,0 +1,482 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+const notebookNameMaxLength = 255
+
+var errNotebookCycle = errors.New("notebook cycle")
+
+// notebookSubtree returns a query selecting the ids of a notebook and all its
+// descendants, with the notebook id in placeholder $param
+func notebookSubtree(param int) string {
+	return fmt.Sprintf(`WITH RECURSIVE subtree AS (
+		SELECT id FROM notebooks WHERE id = $%d
+		UNION ALL
+		SELECT nb.id FROM notebooks nb JOIN subtree ON nb.parent_id = subtree.id
+	) SELECT id FROM subtree`, param)
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ListNotebooks lists the current workspace's notebooks with their direct note counts.
+// The list is flat; clients build the tree from parent_id.
+func ListNotebooks(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		workspaceID, _ := currentWorkspace(r)
+
+		var notebooks []models.Notebook
+		err := cb.Call(func() error {
+			rows, err := db.Query(
+				`SELECT nb.id, nb.parent_id, nb.name, COUNT(n.id), nb.created_at, nb.updated_at FROM notebooks nb
+				LEFT JOIN notes n ON n.notebook_id = nb.id AND n.deleted_at IS NULL
+				WHERE nb.workspace_id = $1 GROUP BY nb.id ORDER BY LOWER(nb.name)`,
+				workspaceID,
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			notebooks = []models.Notebook{}
+			for rows.Next() {
+				var notebook models.Notebook
+				if err := rows.Scan(&notebook.ID, &notebook.ParentID, &notebook.Name, &notebook.NoteCount, &notebook.CreatedAt, &notebook.UpdatedAt); err != nil {
+					return err
+				}
+				notebooks = append(notebooks, notebook)
+			}
+			return rows.Err()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch notebooks",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(notebooks)
+	}
+}
+
+// CreateNotebook creates a notebook, at the top level or inside parent_id
+func CreateNotebook(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceEditor, "You don't have permission to create notebooks in this workspace") {
+			return
+		}
+		workspaceID, _ := currentWorkspace(r)
+
+		req, ok := decodeNotebookRequest(w, r)
+		if !ok {
+			return
+		}
+
+		notebook := models.Notebook{ParentID: req.ParentID, Name: req.Name}
+		err := cb.Call(func() error {
+			if req.ParentID != nil {
+				if err := findNotebook(db, *req.ParentID, workspaceID); err != nil {
+					return err
+				}
+			}
+
+			return db.QueryRow(
+				"INSERT INTO notebooks (workspace_id, parent_id, name) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at",
+				workspaceID, req.ParentID, req.Name,
+			).Scan(&notebook.ID, &notebook.CreatedAt, &notebook.UpdatedAt)
+		})
+
+		if writeNotebookError(w, err, "Failed to create notebook") {
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(notebook)
+	}
+}
+
+// RenameNotebook renames a notebook
+func RenameNotebook(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceEditor, "You don't have permission to rename notebooks in this workspace") {
+			return
+		}
+		workspaceID, _ := currentWorkspace(r)
+
+		notebookID, ok := parseNotebookID(w, r)
+		if !ok {
+			return
+		}
+
+		req, ok := decodeNotebookRequest(w, r)
+		if !ok {
+			return
+		}
+
+		err := cb.Call(func() error {
+			result, err := db.Exec(
+				"UPDATE notebooks SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND workspace_id = $3",
+				req.Name, notebookID, workspaceID,
+			)
+			if err != nil {
+				return err
+			}
+			if affected, err := result.RowsAffected(); err != nil {
+				return err
+			} else if affected == 0 {
+				return sql.ErrNoRows
+			}
+			return nil
+		})
+
+		if writeNotebookError(w, err, "Failed to rename notebook") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Notebook renamed successfully"})
+	}
+}
+
+// MoveNotebook moves a notebook under another one, or to the top level.
+// A notebook can't be moved into itself or one of its descendants.
+func MoveNotebook(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceEditor, "You don't have permission to move notebooks in this workspace") {
+			return
+		}
+		workspaceID, _ := currentWorkspace(r)
+
+		notebookID, ok := parseNotebookID(w, r)
+		if !ok {
+			return
+		}
+
+		var req models.MoveNotebookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		err := cb.Call(func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			// Serialize tree changes in the workspace so concurrent moves can't form a cycle
+			if _, err := tx.Exec("SELECT 1 FROM workspaces WHERE id = $1 FOR UPDATE", workspaceID); err != nil {
+				return err
+			}
+
+			if err := findNotebook(tx, notebookID, workspaceID); err != nil {
+				return err
+			}
+
+			if req.ParentID != nil {
+				if err := findNotebook(tx, *req.ParentID, workspaceID); err != nil {
+					return err
+				}
+
+				var cycle bool
+				if err := tx.QueryRow(
+					"SELECT $2 IN ("+notebookSubtree(1)+")",
+					notebookID, *req.ParentID,
+				).Scan(&cycle); err != nil {
+					return err
+				}
+				if cycle {
+					return errNotebookCycle
+				}
+			}
+
+			if _, err := tx.Exec(
+				"UPDATE notebooks SET parent_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+				req.ParentID, notebookID,
+			); err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		})
+
+		if writeNotebookError(w, err, "Failed to move notebook") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Notebook moved successfully"})
+	}
+}
+
+// DeleteNotebook deletes a notebook. By default its notes and sub-notebooks move
+// up to its parent; with ?mode=cascade they are deleted along with it.
+func DeleteNotebook(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceEditor, "You don't have permission to delete notebooks in this workspace") {
+			return
+		}
+		workspaceID, _ := currentWorkspace(r)
+
+		notebookID, ok := parseNotebookID(w, r)
+		if !ok {
+			return
+		}
+
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = "reparent"
+		}
+		if mode != "reparent" && mode != "cascade" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "mode must be reparent or cascade",
+			})
+			return
+		}
+
+		var affectedNotes []int
+		err := cb.Call(func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if _, err := tx.Exec("SELECT 1 FROM workspaces WHERE id = $1 FOR UPDATE", workspaceID); err != nil {
+				return err
+			}
+
+			var parentID sql.NullInt64
+			if err := tx.QueryRow(
+				"SELECT parent_id FROM notebooks WHERE id = $1 AND workspace_id = $2",
+				notebookID, workspaceID,
+			).Scan(&parentID); err != nil {
+				return err
+			}
+
+			// Notes are handled first; sub-notebooks go with the notebook through ON DELETE CASCADE.
+			// Cascading moves the notes to the trash, where they are left out of any notebook.
+			notesQuery := "UPDATE notes SET notebook_id = $2, version = version + 1 WHERE notebook_id = $1 RETURNING id"
+			notesArgs := []interface{}{notebookID, parentID}
+			if mode == "cascade" {
+				notesQuery = `UPDATE notes SET deleted_at = CURRENT_TIMESTAMP, version = version + 1
+				WHERE deleted_at IS NULL AND notebook_id IN (` + notebookSubtree(1) + `) RETURNING id`
+				notesArgs = notesArgs[:1]
+			} else if _, err := tx.Exec("UPDATE notebooks SET parent_id = $2 WHERE parent_id = $1", notebookID, parentID); err != nil {
+				return err
+			}
+
+			if affectedNotes, err = scanIDs(tx.Query(notesQuery, notesArgs...)); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec("DELETE FROM notebooks WHERE id = $1", notebookID); err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		})
+
+		if writeNotebookError(w, err, "Failed to delete notebook") {
+			return
+		}
+
+		// Invalidate cache
+		for _, noteID := range affectedNotes {
+			cache.Delete(fmt.Sprintf("note:%d", noteID))
+		}
+		cache.Delete(notesCacheKey(workspaceID))
+
+		recordAudit(db, cb, r, auditEvent{
+			UserID:     userID,
+			Action:     auditNotebookDelete,
+			TargetType: "notebook",
+			TargetID:   notebookID,
+			Details:    map[string]string{"mode": mode, "notes": strconv.Itoa(len(affectedNotes))},
+		})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Notebook deleted successfully"})
+	}
+}
+
+// MoveNote files a note in a notebook of its workspace, or takes it out of its notebook
+func MoveNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		var req models.MoveNoteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok {
+			return
+		}
+
+		if !requireRole(w, access.WorkspaceRole, models.WorkspaceEditor, "You don't have permission to move this note") {
+			return
+		}
+
+		err := cb.Call(func() error {
+			if req.NotebookID != nil {
+				if err := findNotebook(db, *req.NotebookID, access.WorkspaceID); err != nil {
+					return err
+				}
+			}
+
+			_, err := db.Exec("UPDATE notes SET notebook_id = $1, version = version + 1 WHERE id = $2", req.NotebookID, noteID)
+			return err
+		})
+
+		if writeNotebookError(w, err, "Failed to move note") {
+			return
+		}
+
+		// Invalidate cache
+		cache.Delete(fmt.Sprintf("note:%d", noteID))
+		cache.Delete(notesCacheKey(access.WorkspaceID))
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Note moved successfully"})
+	}
+}
+
+// findNotebook checks that a notebook exists in the workspace, failing with sql.ErrNoRows if not
+func findNotebook(q rowQuerier, notebookID, workspaceID int) error {
+	var id int
+	return q.QueryRow("SELECT id FROM notebooks WHERE id = $1 AND workspace_id = $2", notebookID, workspaceID).Scan(&id)
+}
+
+// scanIDs reads a single integer column from every row
+func scanIDs(rows *sql.Rows, err error) ([]int, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// decodeNotebookRequest reads and validates a notebook request, writing a 400 if it is invalid
+func decodeNotebookRequest(w http.ResponseWriter, r *http.Request) (models.NotebookRequest, bool) {
+	var req models.NotebookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Failed to parse request body",
+		})
+		return req, false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > notebookNameMaxLength {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("Name is required and must be at most %d characters", notebookNameMaxLength),
+		})
+		return req, false
+	}
+
+	return req, true
+}
+
+// writeNotebookError writes the response for a failed notebook change.
+// It reports whether err was an error.
+func writeNotebookError(w http.ResponseWriter, err error, message string) bool {
+	switch {
+	case err == nil:
+		return false
+	case err == sql.ErrNoRows:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "not_found",
+			Message: "Notebook not found",
+		})
+	case err == errNotebookCycle:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "validation_error",
+			Message: "A notebook can't be moved into itself or one of its notebooks",
+		})
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "server_error",
+			Message: message,
+		})
+	}
+	return true
+}
+
+// parseNotebookID reads the {notebook_id} path variable, writing a 400 if it is invalid
+func parseNotebookID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	notebookID, err := strconv.Atoi(mux.Vars(r)["notebook_id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Invalid notebook ID",
+		})
+		return 0, false
+	}
+	return notebookID, true
+}
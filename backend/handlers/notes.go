@@ -6,19 +6,30 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"vicnotes/backend/models"
 	"vicnotes/backend/utils"
 )
 
+// noteTitleMaxLength is the length of the notes.title column
+const noteTitleMaxLength = 255
+
 // CreateNote handles note creation
 func CreateNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		userID := r.Context().Value("user_id").(int)
+		workspaceID, _ := currentWorkspace(r)
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceEditor, "You don't have permission to create notes in this workspace") {
+			return
+		}
 
 		var req models.CreateNoteRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -30,23 +41,44 @@ func CreateNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker)
 			return
 		}
 
-		if req.Title == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrorResponse{
-				Error:   "validation_error",
-				Message: "Title is required",
-			})
+		if !validNoteTitle(w, req.Title) {
 			return
 		}
 
 		var noteID int
 		err := cb.Call(func() error {
-			return db.QueryRow(
-				"INSERT INTO notes (user_id, title, content) VALUES ($1, $2, $3) RETURNING id",
-				userID, req.Title, req.Content,
-			).Scan(&noteID)
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if req.NotebookID != nil {
+				if err := findNotebook(tx, *req.NotebookID, workspaceID); err != nil {
+					return err
+				}
+			}
+
+			if err := tx.QueryRow(
+				`INSERT INTO notes (user_id, workspace_id, notebook_id, title, content, pinned, search_language)
+				VALUES ($1, $2, $3, $4, $5, $6, (SELECT search_language FROM users WHERE id = $1)::regconfig) RETURNING id`,
+				userID, workspaceID, req.NotebookID, req.Title, req.Content, req.Pinned,
+			).Scan(&noteID); err != nil {
+				return err
+			}
+
+			if err := saveRevision(tx, noteID, userID); err != nil {
+				return err
+			}
+
+			return tx.Commit()
 		})
 
+		if err == sql.ErrNoRows {
+			writeNotebookError(w, err, "")
+			return
+		}
+
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(models.ErrorResponse{
@@ -57,30 +89,98 @@ func CreateNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker)
 		}
 
 		note := models.Note{
-			ID:      noteID,
-			UserID:  userID,
-			Title:   req.Title,
-			Content: req.Content,
+			ID:          noteID,
+			UserID:      userID,
+			WorkspaceID: workspaceID,
+			NotebookID:  req.NotebookID,
+			Title:       req.Title,
+			Content:     req.Content,
+			Pinned:      req.Pinned,
+			Version:     1,
+			Tags:        []models.Tag{},
 		}
 
-		// Invalidate cache for user's notes
-		cache.Delete(fmt.Sprintf("notes:%d", userID))
+		// Invalidate cache for the workspace's notes
+		cache.Delete(notesCacheKey(workspaceID))
 
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditNoteCreate, TargetType: "note", TargetID: noteID})
+
+		w.Header().Set("ETag", noteETag(note.Version))
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(note)
 	}
 }
 
-// ListNotes handles listing user's notes
+// ListNotes handles listing the current workspace's notes, or with ?shared=true
+// the notes other users shared with the caller.
+// ?tags=a,b keeps notes carrying all the tags, or any of them with &match=any.
+// ?notebook_id= keeps the notebook's notes, including sub-notebooks with &recursive=true.
 func ListNotes(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
-		userID := r.Context().Value("user_id").(int)
-		cacheKey := fmt.Sprintf("notes:%d", userID)
+		query := r.URL.Query()
+		if query.Get("shared") == "true" {
+			writeSharedNotes(w, r, db, cb)
+			return
+		}
+
+		workspaceID, _ := currentWorkspace(r)
+		conditions := []string{"n.workspace_id = $1", "n.deleted_at IS NULL"}
+		args := []interface{}{workspaceID}
+		baseConditions := len(conditions)
+
+		if tagNames := parseTagNames(query.Get("tags")); len(tagNames) > 0 {
+			match := query.Get("match")
+			if match != "" && match != "all" && match != "any" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "invalid_request",
+					Message: "match must be all or any",
+				})
+				return
+			}
+
+			// Count how many of the requested tags each note carries
+			args = append(args, pq.Array(tagNames))
+			matched := fmt.Sprintf(
+				`(SELECT COUNT(DISTINCT LOWER(t.name)) FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+				WHERE nt.note_id = n.id AND LOWER(t.name) = ANY($%d))`,
+				len(args),
+			)
+			if match == "any" {
+				conditions = append(conditions, matched+" > 0")
+			} else {
+				args = append(args, len(tagNames))
+				conditions = append(conditions, fmt.Sprintf("%s = $%d", matched, len(args)))
+			}
+		}
+
+		if notebook := query.Get("notebook_id"); notebook != "" {
+			notebookID, err := strconv.Atoi(notebook)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "invalid_request",
+					Message: "Invalid notebook ID",
+				})
+				return
+			}
+
+			args = append(args, notebookID)
+			if query.Get("recursive") == "true" {
+				conditions = append(conditions, "n.notebook_id IN ("+notebookSubtree(len(args))+")")
+			} else {
+				conditions = append(conditions, fmt.Sprintf("n.notebook_id = $%d", len(args)))
+			}
+		}
+
+		// Only the unfiltered list is cached
+		cacheKey := notesCacheKey(workspaceID)
+		filtered := len(conditions) > baseConditions
 
 		// Try to get from cache first
-		if cached, ok := cache.Get(cacheKey); ok {
+		if cached, ok := cache.Get(cacheKey); ok && !filtered {
 			w.WriteHeader(http.StatusOK)
 			json.NewEncoder(w).Encode(cached)
 			return
@@ -89,8 +189,9 @@ func ListNotes(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) h
 		var notes []models.Note
 		err := cb.Call(func() error {
 			rows, err := db.Query(
-				"SELECT id, user_id, title, content, created_at, updated_at FROM notes WHERE user_id = $1 ORDER BY created_at DESC",
-				userID,
+				"SELECT "+noteColumns+" FROM notes n WHERE "+
+					strings.Join(conditions, " AND ")+" ORDER BY n.created_at DESC",
+				args...,
 			)
 			if err != nil {
 				return err
@@ -100,12 +201,16 @@ func ListNotes(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) h
 			notes = []models.Note{}
 			for rows.Next() {
 				var note models.Note
-				if err := rows.Scan(&note.ID, &note.UserID, &note.Title, &note.Content, &note.CreatedAt, &note.UpdatedAt); err != nil {
+				if err := scanNote(rows, &note); err != nil {
 					return err
 				}
 				notes = append(notes, note)
 			}
-			return nil
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			return attachTags(db, notes)
 		})
 
 		if err != nil {
@@ -118,19 +223,22 @@ func ListNotes(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) h
 		}
 
 		// Cache the result for 5 minutes
-		cache.Set(cacheKey, notes, 5*time.Minute)
+		if !filtered {
+			cache.Set(cacheKey, notes, 5*time.Minute)
+		}
 
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(notes)
 	}
 }
 
-// GetNote handles fetching a single note
+// GetNote handles fetching a single note from the current workspace or shared with the caller
 func GetNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		userID := r.Context().Value("user_id").(int)
+		workspaceID, _ := currentWorkspace(r)
 		vars := mux.Vars(r)
 		noteID, err := strconv.Atoi(vars["id"])
 		if err != nil {
@@ -144,19 +252,31 @@ func GetNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) htt
 
 		cacheKey := fmt.Sprintf("note:%d", noteID)
 
-		// Try to get from cache first
-		if cached, ok := cache.Get(cacheKey); ok {
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(cached)
+		// Try to get from cache first; the cached note must belong to the current workspace
+		if cached, ok := cache.Get(cacheKey); ok && cached.(models.Note).WorkspaceID == workspaceID {
+			writeNote(w, r, cached.(models.Note))
 			return
 		}
 
 		var note models.Note
 		err = cb.Call(func() error {
-			return db.QueryRow(
-				"SELECT id, user_id, title, content, created_at, updated_at FROM notes WHERE id = $1 AND user_id = $2",
-				noteID, userID,
-			).Scan(&note.ID, &note.UserID, &note.Title, &note.Content, &note.CreatedAt, &note.UpdatedAt)
+			row := db.QueryRow(
+				`SELECT `+noteColumns+` FROM notes n
+				WHERE n.id = $1 AND n.deleted_at IS NULL
+				AND (n.workspace_id = $2 OR EXISTS (SELECT 1 FROM note_shares s WHERE s.note_id = n.id AND s.user_id = $3))`,
+				noteID, workspaceID, userID,
+			)
+			err = scanNote(row, &note)
+			if err != nil {
+				return err
+			}
+
+			notes := []models.Note{note}
+			if err := attachTags(db, notes); err != nil {
+				return err
+			}
+			note = notes[0]
+			return nil
 		})
 
 		if err == sql.ErrNoRows {
@@ -180,8 +300,7 @@ func GetNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) htt
 		// Cache the result for 5 minutes
 		cache.Set(cacheKey, note, 5*time.Minute)
 
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(note)
+		writeNote(w, r, note)
 	}
 }
 
@@ -212,37 +331,53 @@ func UpdateNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker)
 			return
 		}
 
-		// Verify ownership
-		var existingUserID int
-		err = cb.Call(func() error {
-			return db.QueryRow("SELECT user_id FROM notes WHERE id = $1", noteID).Scan(&existingUserID)
-		})
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(models.ErrorResponse{
-				Error:   "not_found",
-				Message: "Note not found",
-			})
+		if !validNoteTitle(w, req.Title) {
 			return
 		}
 
-		if existingUserID != userID {
-			w.WriteHeader(http.StatusForbidden)
-			json.NewEncoder(w).Encode(models.ErrorResponse{
-				Error:   "forbidden",
-				Message: "You don't have permission to update this note",
-			})
+		// Verify the caller may edit the note through the workspace or a share
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok {
 			return
 		}
 
+		if !requireRole(w, access.role(), models.WorkspaceEditor, "You don't have permission to update this note") {
+			return
+		}
+
+		versions, ok := parseIfMatch(w, r)
+		if !ok {
+			return
+		}
+
+		var version int
 		err = cb.Call(func() error {
-			_, err := db.Exec(
-				"UPDATE notes SET title = $1, content = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3",
-				req.Title, req.Content, noteID,
-			)
-			return err
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if err := tx.QueryRow(
+				`UPDATE notes SET title = $1, content = $2, pinned = COALESCE($3, pinned), version = version + 1, updated_at = CURRENT_TIMESTAMP
+				WHERE id = $4 AND deleted_at IS NULL AND ($5::bigint[] IS NULL OR version = ANY($5)) RETURNING version`,
+				req.Title, req.Content, req.Pinned, noteID, pq.Array(versions),
+			).Scan(&version); err != nil {
+				return err
+			}
+
+			if err := saveRevision(tx, noteID, userID); err != nil {
+				return err
+			}
+
+			return tx.Commit()
 		})
 
+		if err == sql.ErrNoRows {
+			writeVersionConflict(w, db, cb, noteID)
+			return
+		}
+
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(models.ErrorResponse{
@@ -254,14 +389,17 @@ func UpdateNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker)
 
 		// Invalidate cache
 		cache.Delete(fmt.Sprintf("note:%d", noteID))
-		cache.Delete(fmt.Sprintf("notes:%d", userID))
+		cache.Delete(notesCacheKey(access.WorkspaceID))
 
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditNoteUpdate, TargetType: "note", TargetID: noteID})
+
+		w.Header().Set("ETag", noteETag(version))
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{"message": "Note updated successfully"})
 	}
 }
 
-// DeleteNote handles note deletion
+// DeleteNote handles note deletion by moving the note to the trash
 func DeleteNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -278,31 +416,29 @@ func DeleteNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker)
 			return
 		}
 
-		// Verify ownership
-		var existingUserID int
-		err = cb.Call(func() error {
-			return db.QueryRow("SELECT user_id FROM notes WHERE id = $1", noteID).Scan(&existingUserID)
-		})
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(models.ErrorResponse{
-				Error:   "not_found",
-				Message: "Note not found",
-			})
+		// Verify the caller may edit in the note's workspace; shares don't allow deleting
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok {
 			return
 		}
 
-		if existingUserID != userID {
-			w.WriteHeader(http.StatusForbidden)
-			json.NewEncoder(w).Encode(models.ErrorResponse{
-				Error:   "forbidden",
-				Message: "You don't have permission to delete this note",
-			})
+		if !requireRole(w, access.WorkspaceRole, models.WorkspaceEditor, "You don't have permission to delete this note") {
+			return
+		}
+
+		versions, ok := parseIfMatch(w, r)
+		if !ok {
 			return
 		}
 
+		var result sql.Result
 		err = cb.Call(func() error {
-			_, err := db.Exec("DELETE FROM notes WHERE id = $1", noteID)
+			var err error
+			result, err = db.Exec(
+				`UPDATE notes SET deleted_at = CURRENT_TIMESTAMP, version = version + 1
+				WHERE id = $1 AND deleted_at IS NULL AND ($2::bigint[] IS NULL OR version = ANY($2))`,
+				noteID, pq.Array(versions),
+			)
 			return err
 		})
 
@@ -315,11 +451,120 @@ func DeleteNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker)
 			return
 		}
 
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			writeVersionConflict(w, db, cb, noteID)
+			return
+		}
+
 		// Invalidate cache
 		cache.Delete(fmt.Sprintf("note:%d", noteID))
-		cache.Delete(fmt.Sprintf("notes:%d", userID))
+		cache.Delete(notesCacheKey(access.WorkspaceID))
+
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditNoteDelete, TargetType: "note", TargetID: noteID})
 
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"message": "Note deleted successfully"})
+		json.NewEncoder(w).Encode(map[string]string{"message": "Note moved to trash"})
 	}
 }
+
+// validNoteTitle writes a 400 unless title is a valid note title
+func validNoteTitle(w http.ResponseWriter, title string) bool {
+	if strings.TrimSpace(title) != "" && utf8.RuneCountInString(title) <= noteTitleMaxLength {
+		return true
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   "validation_error",
+		Message: fmt.Sprintf("Title is required and must be at most %d characters", noteTitleMaxLength),
+	})
+	return false
+}
+
+// noteColumns are the columns read by scanNote, for the notes table aliased n
+const noteColumns = "n.id, n.user_id, n.workspace_id, n.notebook_id, n.title, n.content, n.pinned, n.version, n.created_at, n.updated_at, n.deleted_at"
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanNote scans noteColumns into note, followed by any extra columns
+func scanNote(row rowScanner, note *models.Note, extra ...interface{}) error {
+	dest := []interface{}{&note.ID, &note.UserID, &note.WorkspaceID, &note.NotebookID, &note.Title, &note.Content, &note.Pinned, &note.Version, &note.CreatedAt, &note.UpdatedAt, &note.DeletedAt}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// noteAccess is what the caller may do with a note
+type noteAccess struct {
+	WorkspaceID int
+	OwnerID     int
+	// WorkspaceRole is the caller's role when the note is in the current workspace
+	WorkspaceRole string
+	// SharePermission is the permission the note was shared with the caller at
+	SharePermission string
+}
+
+// role is the higher of the workspace role and the share permission
+func (a noteAccess) role() string {
+	if workspaceRoleRank[a.SharePermission] > workspaceRoleRank[a.WorkspaceRole] {
+		return a.SharePermission
+	}
+	return a.WorkspaceRole
+}
+
+// loadNoteAccess looks up the caller's access to a note, writing a 404 if they have none.
+// Notes in workspaces the caller can't see are reported as missing too, as are notes in the trash.
+func loadNoteAccess(w http.ResponseWriter, r *http.Request, db *sql.DB, cb *utils.CircuitBreaker, noteID int) (noteAccess, bool) {
+	return findNoteAccess(w, r, db, cb, noteID, false)
+}
+
+// loadTrashedNoteAccess is loadNoteAccess for notes in the trash, which only members
+// of the note's workspace can reach
+func loadTrashedNoteAccess(w http.ResponseWriter, r *http.Request, db *sql.DB, cb *utils.CircuitBreaker, noteID int) (noteAccess, bool) {
+	return findNoteAccess(w, r, db, cb, noteID, true)
+}
+
+func findNoteAccess(w http.ResponseWriter, r *http.Request, db *sql.DB, cb *utils.CircuitBreaker, noteID int, trashed bool) (noteAccess, bool) {
+	userID := r.Context().Value("user_id").(int)
+	workspaceID, workspaceRole := currentWorkspace(r)
+
+	var access noteAccess
+	err := cb.Call(func() error {
+		return db.QueryRow(
+			`SELECT n.workspace_id, n.user_id, COALESCE(s.permission, '') FROM notes n
+			LEFT JOIN note_shares s ON s.note_id = n.id AND s.user_id = $2
+			WHERE n.id = $1 AND (n.deleted_at IS NOT NULL) = $3`,
+			noteID, userID, trashed,
+		).Scan(&access.WorkspaceID, &access.OwnerID, &access.SharePermission)
+	})
+
+	// Shares don't reach into the trash
+	if trashed {
+		access.SharePermission = ""
+	}
+
+	if err != nil && err != sql.ErrNoRows {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to fetch note",
+		})
+		return access, false
+	}
+
+	if err == nil && access.WorkspaceID == workspaceID {
+		access.WorkspaceRole = workspaceRole
+	}
+
+	if access.role() == "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "not_found",
+			Message: "Note not found",
+		})
+		return access, false
+	}
+
+	return access, true
+}
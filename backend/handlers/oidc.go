@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"vicnotes/backend/config"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+const (
+	// oidcStateTTL is how long a user has to finish logging in at the provider
+	oidcStateTTL = 10 * time.Minute
+	// oidcStateCookie ties a login to the browser that started it
+	oidcStateCookie = "vicnotes_oidc_state"
+	// unusablePasswordHash never matches a password, for accounts created through OIDC
+	unusablePasswordHash = "!"
+)
+
+var (
+	errOIDCNoAccount        = errors.New("no account for this identity")
+	errOIDCEmailNotVerified = errors.New("identity has no verified email")
+)
+
+// oidcLoginState is kept in the cache between the redirect to the provider and the callback
+type oidcLoginState struct {
+	Nonce        string
+	CodeVerifier string
+}
+
+// OIDCLogin redirects the browser to the identity provider to start a login
+func OIDCLogin(cache *utils.SimpleCache, provider *utils.OIDCProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := utils.GenerateRandomToken(24)
+		if err != nil {
+			writeOIDCError(w, http.StatusInternalServerError, "server_error", "Failed to start login")
+			return
+		}
+
+		nonce, err := utils.GenerateRandomToken(24)
+		if err != nil {
+			writeOIDCError(w, http.StatusInternalServerError, "server_error", "Failed to start login")
+			return
+		}
+
+		verifier, challenge, err := utils.GeneratePKCE()
+		if err != nil {
+			writeOIDCError(w, http.StatusInternalServerError, "server_error", "Failed to start login")
+			return
+		}
+
+		authURL, err := provider.AuthCodeURL(state, nonce, challenge)
+		if err != nil {
+			log.Printf("OIDC login failed: %v", err)
+			writeOIDCError(w, http.StatusBadGateway, "oidc_unavailable", "Identity provider is unavailable")
+			return
+		}
+
+		cache.Set("oidc:"+state, oidcLoginState{Nonce: nonce, CodeVerifier: verifier}, oidcStateTTL)
+		http.SetCookie(w, oidcStateCookieFor(state, int(oidcStateTTL.Seconds())))
+
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// OIDCCallback finishes a login at the identity provider. The user is matched by
+// provider subject, then linked by verified email, then provisioned if allowed.
+// The browser is sent back to the frontend with the token in the URL fragment,
+// or an MFA token when the account has a second factor to complete.
+func OIDCCallback(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker, provider *utils.OIDCProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		if query.Get("error") != "" {
+			redirectOIDCResult(w, r, url.Values{"error": {"oidc_denied"}})
+			return
+		}
+
+		// Without this check an attacker could send a victim the callback URL of the
+		// attacker's own login and sign them in to the attacker's account
+		stateCookie, err := r.Cookie(oidcStateCookie)
+		http.SetCookie(w, oidcStateCookieFor("", -1))
+		if err != nil || query.Get("state") == "" ||
+			subtle.ConstantTimeCompare([]byte(stateCookie.Value), []byte(query.Get("state"))) != 1 {
+			redirectOIDCResult(w, r, url.Values{"error": {"invalid_state"}})
+			return
+		}
+
+		cacheKey := "oidc:" + query.Get("state")
+		cached, ok := cache.Get(cacheKey)
+		if !ok {
+			redirectOIDCResult(w, r, url.Values{"error": {"invalid_state"}})
+			return
+		}
+		cache.Delete(cacheKey)
+		state := cached.(oidcLoginState)
+
+		rawIDToken, err := provider.Exchange(query.Get("code"), state.CodeVerifier)
+		if err != nil {
+			log.Printf("OIDC code exchange failed: %v", err)
+			redirectOIDCResult(w, r, url.Values{"error": {"oidc_exchange_failed"}})
+			return
+		}
+
+		claims, err := provider.VerifyIDToken(rawIDToken, state.Nonce)
+		if err != nil {
+			log.Printf("OIDC id token rejected: %v", err)
+			redirectOIDCResult(w, r, url.Values{"error": {"invalid_id_token"}})
+			return
+		}
+
+		email := utils.NormalizeEmail(claims.Email)
+		if !utils.ValidateEmail(email) {
+			email = ""
+		}
+
+		var user models.User
+		var tokenVersion int
+		var resetRequired bool
+		var created bool
+		err = cb.Call(func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			var userID int
+			userID, created, err = findOrProvisionOIDCUser(tx, provider.Issuer(), claims.Subject, email, bool(claims.EmailVerified))
+			if err != nil {
+				return err
+			}
+
+			err = tx.QueryRow(
+				"SELECT id, email, email_verified, totp_enabled, role, disabled_at, deletion_scheduled_at, token_version, password_reset_required FROM users WHERE id = $1",
+				userID,
+			).Scan(&user.ID, &user.Email, &user.EmailVerified, &user.TOTPEnabled, &user.Role, &user.DisabledAt, &user.DeletionScheduledAt, &tokenVersion, &resetRequired)
+			if err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		})
+
+		if err == errOIDCNoAccount {
+			redirectOIDCResult(w, r, url.Values{"error": {"account_not_found"}})
+			return
+		}
+
+		if err == errOIDCEmailNotVerified {
+			redirectOIDCResult(w, r, url.Values{"error": {"email_not_verified"}})
+			return
+		}
+
+		if err != nil {
+			log.Printf("OIDC account lookup failed: %v", err)
+			redirectOIDCResult(w, r, url.Values{"error": {"server_error"}})
+			return
+		}
+
+		if created {
+			recordAudit(db, cb, r, auditEvent{UserID: user.ID, Action: auditRegister, Details: map[string]string{"method": "oidc"}})
+		}
+
+		if refusal := refuseLogin(user, resetRequired); refusal != nil {
+			redirectOIDCResult(w, r, url.Values{"error": {refusal.Error}})
+			return
+		}
+
+		token, mfaToken, err := issueLogin(user, tokenVersion)
+		if err != nil {
+			redirectOIDCResult(w, r, url.Values{"error": {"server_error"}})
+			return
+		}
+
+		// The provider stands in for the password; the second factor is still needed
+		if mfaToken != "" {
+			redirectOIDCResult(w, r, url.Values{"mfa_token": {mfaToken}})
+			return
+		}
+
+		recordAudit(db, cb, r, auditEvent{UserID: user.ID, Action: auditLoginSuccess, Details: map[string]string{"method": "oidc"}})
+
+		// In cookie session mode the token travels as a cookie, not in the URL
+		if token = deliverToken(w, token); token == "" {
+			redirectOIDCResult(w, r, url.Values{"session": {"cookie"}})
+			return
+		}
+		redirectOIDCResult(w, r, url.Values{"token": {token}})
+	}
+}
+
+// findOrProvisionOIDCUser resolves the local account for a provider identity
+// and reports whether a new account was provisioned for it. Identities that
+// aren't linked yet need an email the provider verified.
+func findOrProvisionOIDCUser(tx *sql.Tx, issuer, subject, email string, emailVerified bool) (int, bool, error) {
+	var userID int
+	err := tx.QueryRow(
+		"SELECT user_id FROM oidc_identities WHERE issuer = $1 AND subject = $2",
+		issuer, subject,
+	).Scan(&userID)
+	if err == nil {
+		return userID, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	// Otherwise an unverified email could take over the account that owns it
+	if email == "" || !emailVerified {
+		return 0, false, errOIDCEmailNotVerified
+	}
+
+	// Link to an existing account with the same email; the provider verified it
+	created := false
+	err = tx.QueryRow("SELECT id FROM users WHERE LOWER(email) = $1", email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		if !config.OIDCAutoProvision() || !registrationAllowsProvisioning(email) {
+			return 0, false, errOIDCNoAccount
+		}
+
+		err = tx.QueryRow(
+			"INSERT INTO users (email, password_hash, email_verified, role) VALUES ($1, $2, TRUE, $3) RETURNING id",
+			email, unusablePasswordHash, roleForNewUser(email),
+		).Scan(&userID)
+		if err != nil {
+			return 0, false, err
+		}
+		if err := createPersonalWorkspace(tx, userID); err != nil {
+			return 0, false, err
+		}
+		created = true
+	} else if err != nil {
+		return 0, false, err
+	} else {
+		if _, err := tx.Exec(
+			"UPDATE users SET email_verified = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = $1",
+			userID,
+		); err != nil {
+			return 0, false, err
+		}
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO oidc_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4)",
+		userID, issuer, subject, email,
+	); err != nil {
+		return 0, false, err
+	}
+
+	return userID, created, nil
+}
+
+// oidcStateCookieFor builds the cookie holding a login's state; a negative maxAge deletes it.
+// It is Lax whatever SESSION_COOKIE_SAMESITE says, so it comes back with the provider's redirect.
+func oidcStateCookieFor(state string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   config.SessionCookieSecure(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// redirectOIDCResult sends the browser back to the frontend with the result in the URL
+// fragment, which keeps tokens out of server logs and Referer headers
+func redirectOIDCResult(w http.ResponseWriter, r *http.Request, result url.Values) {
+	http.Redirect(w, r, config.GetAppBaseURL()+"/oidc/callback#"+result.Encode(), http.StatusFound)
+}
+
+// writeOIDCError writes a JSON error for requests that never reached the provider
+func writeOIDCError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   code,
+		Message: message,
+	})
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"vicnotes/backend/config"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+const (
+	// resetReasonRequested is a reset the user asked for
+	resetReasonRequested = "requested"
+	// resetReasonForced is a reset an admin required
+	resetReasonForced = "forced"
+)
+
+// sendPasswordResetEmail creates a reset token and mails the link in the background,
+// so response time doesn't reveal whether the account exists
+func sendPasswordResetEmail(db *sql.DB, cb *utils.CircuitBreaker, mailer utils.Mailer, userID int, email, reason string) error {
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+
+	ttl := config.GetPasswordResetTTL()
+	err = cb.Call(func() error {
+		_, err := db.Exec(
+			"INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second')",
+			userID, utils.HashToken(token), int(ttl.Seconds()),
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store reset token: %w", err)
+	}
+
+	intro := "Someone requested a password reset for your VicNotes account."
+	outro := "If you didn't request this, you can ignore this email."
+	if reason == resetReasonForced {
+		intro = "An administrator requires you to choose a new password for your VicNotes account."
+		outro = "If the link expires, use \"Forgot password\" on the login page to get a new one."
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", config.GetAppBaseURL(), token)
+	body := fmt.Sprintf(
+		"%s\n\nUse the link below to choose a new password. It expires in %s and can only be used once.\n\n%s\n\n%s",
+		intro, ttl, link, outro,
+	)
+
+	go func() {
+		if err := mailer.Send(email, "Reset your VicNotes password", body); err != nil {
+			log.Printf("Failed to send password reset email: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// ForgotPassword emails a single-use password reset link to the user.
+// The response is the same whether or not the email is registered.
+func ForgotPassword(db *sql.DB, cb *utils.CircuitBreaker, mailer utils.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req models.ForgotPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		req.Email = utils.NormalizeEmail(req.Email)
+		if req.Email == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Email is required",
+			})
+			return
+		}
+
+		var userID int
+		err := cb.Call(func() error {
+			return db.QueryRow("SELECT id FROM users WHERE LOWER(email) = $1", req.Email).Scan(&userID)
+		})
+
+		if err != nil && err != sql.ErrNoRows {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to query user",
+			})
+			return
+		}
+
+		if err == nil {
+			if err := sendPasswordResetEmail(db, cb, mailer, userID, req.Email, resetReasonRequested); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "server_error",
+					Message: "Failed to create reset token",
+				})
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "If an account exists for this email, a reset link has been sent",
+		})
+	}
+}
+
+// ResetPassword sets a new password using a reset token
+func ResetPassword(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req models.ResetPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		if req.Token == "" || req.Password == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Token and password are required",
+			})
+			return
+		}
+
+		if err := utils.ValidatePassword(req.Password, ""); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "weak_password",
+				Message: err.Error(),
+			})
+			return
+		}
+
+		passwordHash, err := utils.HashPassword(req.Password)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to process password",
+			})
+			return
+		}
+
+		var userID int
+		err = cb.Call(func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			err = tx.QueryRow(
+				"SELECT user_id FROM password_reset_tokens WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP FOR UPDATE",
+				utils.HashToken(req.Token),
+			).Scan(&userID)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(
+				"UPDATE users SET password_hash = $1, token_version = token_version + 1, password_reset_required = FALSE, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+				passwordHash, userID,
+			); err != nil {
+				return err
+			}
+
+			// Consume this token and any other outstanding ones for the user
+			if _, err := tx.Exec(
+				"UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND used_at IS NULL",
+				userID,
+			); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec("DELETE FROM account_export_tokens WHERE user_id = $1", userID); err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		})
+
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_token",
+				Message: "Reset token is invalid or has expired",
+			})
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to reset password",
+			})
+			return
+		}
+
+		// Existing sessions were revoked by the token version bump
+		cache.Delete(fmt.Sprintf("auth:%d", userID))
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditPasswordReset})
+		recordTokensRevoked(db, cb, r, userID, auditPasswordReset)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Password reset successfully"})
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"vicnotes/backend/config"
+	"vicnotes/backend/models"
+)
+
+var errInvalidInvite = errors.New("invalid invite code")
+
+// checkRegistrationPolicy enforces REGISTRATION_MODE before an account is created.
+// It returns nil when registration may go ahead. Invite codes are only checked for
+// presence here; they are consumed in the same transaction that creates the user.
+func checkRegistrationPolicy(email, inviteCode string) *models.ErrorResponse {
+	switch config.GetRegistrationMode() {
+	case config.RegistrationDisabled:
+		return &models.ErrorResponse{
+			Error:   "registration_disabled",
+			Message: "Registration is currently closed",
+		}
+	case config.RegistrationInvite:
+		if strings.TrimSpace(inviteCode) == "" {
+			return &models.ErrorResponse{
+				Error:   "invite_required",
+				Message: "An invite code is required to register",
+			}
+		}
+	case config.RegistrationDomain:
+		if !emailDomainAllowed(email) {
+			return &models.ErrorResponse{
+				Error:   "email_domain_not_allowed",
+				Message: "Registration is restricted to approved email domains",
+			}
+		}
+	}
+	return nil
+}
+
+// registrationAllowsProvisioning reports whether an account may be created without
+// an invite code, as happens when an OIDC login auto-provisions a user
+func registrationAllowsProvisioning(email string) bool {
+	switch config.GetRegistrationMode() {
+	case config.RegistrationOpen:
+		return true
+	case config.RegistrationDomain:
+		return emailDomainAllowed(email)
+	default:
+		return false
+	}
+}
+
+// roleForNewUser returns the role for a new account; emails in ADMIN_EMAILS start as admins
+func roleForNewUser(email string) string {
+	for _, admin := range config.GetAdminEmails() {
+		if email == admin {
+			return models.RoleAdmin
+		}
+	}
+	return models.RoleUser
+}
+
+// emailDomainAllowed reports whether a normalized email is in ALLOWED_EMAIL_DOMAINS
+func emailDomainAllowed(email string) bool {
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, allowed := range config.GetAllowedEmailDomains() {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
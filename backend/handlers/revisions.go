@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"vicnotes/backend/config"
+	"vicnotes/backend/diff"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+const (
+	revisionsDefaultPerPage = 20
+	revisionsMaxPerPage     = 100
+
+	// revisionDiffContext is the number of unchanged lines around each change of a unified diff
+	revisionDiffContext = 3
+)
+
+// ListNoteRevisions lists a note's revisions, newest first
+func ListNoteRevisions(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		page, perPage, ok := parsePagination(r, revisionsDefaultPerPage, revisionsMaxPerPage)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "page and per_page must be positive integers",
+			})
+			return
+		}
+
+		if _, ok := loadNoteAccess(w, r, db, cb, noteID); !ok {
+			return
+		}
+
+		revisions := models.NoteRevisions{Revisions: []models.NoteRevision{}, Page: page, PerPage: perPage}
+		err := cb.Call(func() error {
+			if err := db.QueryRow("SELECT COUNT(*) FROM note_revisions WHERE note_id = $1", noteID).Scan(&revisions.Total); err != nil {
+				return err
+			}
+
+			rows, err := db.Query(
+				`SELECT id, note_id, user_id, title, content, created_at FROM note_revisions
+				WHERE note_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3`,
+				noteID, perPage, (page-1)*perPage,
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				var revision models.NoteRevision
+				if err := rows.Scan(&revision.ID, &revision.NoteID, &revision.UserID, &revision.Title, &revision.Content, &revision.CreatedAt); err != nil {
+					return err
+				}
+				revisions.Revisions = append(revisions.Revisions, revision)
+			}
+			return rows.Err()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch revisions",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(revisions)
+	}
+}
+
+// GetNoteRevision returns one revision of a note
+func GetNoteRevision(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		revisionID, ok := parseRevisionID(w, "revision_id", mux.Vars(r)["revision_id"])
+		if !ok {
+			return
+		}
+
+		if _, ok := loadNoteAccess(w, r, db, cb, noteID); !ok {
+			return
+		}
+
+		var revision models.NoteRevision
+		err := cb.Call(func() error {
+			return findRevision(db, noteID, revisionID, &revision)
+		})
+
+		if writeRevisionError(w, err, "Failed to fetch revision") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(revision)
+	}
+}
+
+// DiffNoteRevisions compares revision ?from= with revision ?to=, or with the latest
+// revision when to is omitted. ?format=unified (default) returns a unified diff of
+// lines; ?format=words returns the inserted, deleted and unchanged runs of words.
+func DiffNoteRevisions(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			format = "unified"
+		}
+		if format != "unified" && format != "words" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "format must be unified or words",
+			})
+			return
+		}
+
+		fromID, ok := parseRevisionID(w, "from", query.Get("from"))
+		if !ok {
+			return
+		}
+		toID := 0
+		if query.Get("to") != "" {
+			if toID, ok = parseRevisionID(w, "to", query.Get("to")); !ok {
+				return
+			}
+		}
+
+		if _, ok := loadNoteAccess(w, r, db, cb, noteID); !ok {
+			return
+		}
+
+		var from, to models.NoteRevision
+		err := cb.Call(func() error {
+			if err := findRevision(db, noteID, fromID, &from); err != nil {
+				return err
+			}
+			if toID == 0 {
+				return db.QueryRow(
+					"SELECT id, note_id, user_id, title, content, created_at FROM note_revisions WHERE note_id = $1 ORDER BY id DESC LIMIT 1",
+					noteID,
+				).Scan(&to.ID, &to.NoteID, &to.UserID, &to.Title, &to.Content, &to.CreatedAt)
+			}
+			return findRevision(db, noteID, toID, &to)
+		})
+
+		if writeRevisionError(w, err, "Failed to compare revisions") {
+			return
+		}
+
+		result := models.NoteRevisionDiff{From: from.ID, To: to.ID, Format: format}
+		before, after := revisionText(from), revisionText(to)
+		if format == "words" {
+			result.Changes = diff.Words(before, after)
+		} else {
+			unified := diff.Unified(before, after, fmt.Sprintf("revision %d", from.ID), fmt.Sprintf("revision %d", to.ID), revisionDiffContext)
+			result.Unified = &unified
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// RestoreNoteRevision brings back the title and content of a revision. The restore
+// is saved as a new revision, so the history before it is kept.
+func RestoreNoteRevision(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		revisionID, ok := parseRevisionID(w, "revision_id", mux.Vars(r)["revision_id"])
+		if !ok {
+			return
+		}
+
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok {
+			return
+		}
+
+		if !requireRole(w, access.role(), models.WorkspaceEditor, "You don't have permission to update this note") {
+			return
+		}
+
+		versions, ok := parseIfMatch(w, r)
+		if !ok {
+			return
+		}
+
+		var version int
+		var revisionFound bool
+		err := cb.Call(func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			var revision models.NoteRevision
+			if err := findRevision(tx, noteID, revisionID, &revision); err != nil {
+				return err
+			}
+			revisionFound = true
+
+			if err := tx.QueryRow(
+				`UPDATE notes SET title = $1, content = $2, version = version + 1, updated_at = CURRENT_TIMESTAMP
+				WHERE id = $3 AND deleted_at IS NULL AND ($4::bigint[] IS NULL OR version = ANY($4)) RETURNING version`,
+				revision.Title, revision.Content, noteID, pq.Array(versions),
+			).Scan(&version); err != nil {
+				return err
+			}
+
+			if err := saveRevision(tx, noteID, userID); err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		})
+
+		// The note was changed or moved to the trash since it was loaded
+		if err == sql.ErrNoRows && revisionFound {
+			writeVersionConflict(w, db, cb, noteID)
+			return
+		}
+
+		if writeRevisionError(w, err, "Failed to restore revision") {
+			return
+		}
+
+		cache.Delete(fmt.Sprintf("note:%d", noteID))
+		cache.Delete(notesCacheKey(access.WorkspaceID))
+
+		recordAudit(db, cb, r, auditEvent{
+			UserID:     userID,
+			Action:     auditNoteRevisionRestore,
+			TargetType: "note",
+			TargetID:   noteID,
+			Details:    map[string]string{"revision_id": strconv.Itoa(revisionID)},
+		})
+
+		w.Header().Set("ETag", noteETag(version))
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Revision restored successfully"})
+	}
+}
+
+// saveRevision records the note's current title and content as a revision by userID,
+// unless they are unchanged since the latest revision, then drops the revisions
+// beyond NOTE_REVISION_LIMIT. Call it in the transaction that changed the note.
+func saveRevision(tx *sql.Tx, noteID, userID int) error {
+	if _, err := tx.Exec(
+		`INSERT INTO note_revisions (note_id, user_id, title, content)
+		SELECT n.id, $2, n.title, COALESCE(n.content, '') FROM notes n
+		WHERE n.id = $1 AND NOT EXISTS (
+			SELECT 1 FROM (SELECT title, content FROM note_revisions WHERE note_id = $1 ORDER BY id DESC LIMIT 1) latest
+			WHERE latest.title = n.title AND latest.content = COALESCE(n.content, '')
+		)`,
+		noteID, userID,
+	); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(
+		`DELETE FROM note_revisions WHERE note_id = $1 AND id <= (
+			SELECT id FROM note_revisions WHERE note_id = $1 ORDER BY id DESC OFFSET $2 LIMIT 1
+		)`,
+		noteID, config.GetNoteRevisionLimit(),
+	)
+	return err
+}
+
+// findRevision loads a revision of a note
+func findRevision(q rowQuerier, noteID, revisionID int, revision *models.NoteRevision) error {
+	return q.QueryRow(
+		"SELECT id, note_id, user_id, title, content, created_at FROM note_revisions WHERE id = $1 AND note_id = $2",
+		revisionID, noteID,
+	).Scan(&revision.ID, &revision.NoteID, &revision.UserID, &revision.Title, &revision.Content, &revision.CreatedAt)
+}
+
+// revisionText is the text revisions are compared by: the title, a blank line, then the content
+func revisionText(revision models.NoteRevision) string {
+	return revision.Title + "\n\n" + revision.Content
+}
+
+// writeRevisionError writes the response for a failed revision query, returning
+// false if there was no error
+func writeRevisionError(w http.ResponseWriter, err error, message string) bool {
+	if err == nil {
+		return false
+	}
+
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "not_found",
+			Message: "Revision not found",
+		})
+		return true
+	}
+
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   "server_error",
+		Message: message,
+	})
+	return true
+}
+
+// parseRevisionID parses a revision ID named name, writing a 400 if it is invalid
+func parseRevisionID(w http.ResponseWriter, name, value string) (int, bool) {
+	revisionID, err := strconv.Atoi(value)
+	if err != nil || revisionID < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: fmt.Sprintf("%s must be a revision ID", name),
+		})
+		return 0, false
+	}
+	return revisionID, true
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+const savedSearchNameMaxLength = 255
+
+// ListSavedSearches lists the caller's saved searches by name
+func ListSavedSearches(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		var searches []models.SavedSearch
+		err := cb.Call(func() error {
+			rows, err := db.Query(
+				"SELECT id, name, query, created_at, updated_at FROM saved_searches WHERE user_id = $1 ORDER BY LOWER(name), id",
+				userID,
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			searches = []models.SavedSearch{}
+			for rows.Next() {
+				var saved models.SavedSearch
+				if err := rows.Scan(&saved.ID, &saved.Name, &saved.Query, &saved.CreatedAt, &saved.UpdatedAt); err != nil {
+					return err
+				}
+				searches = append(searches, saved)
+			}
+			return rows.Err()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch saved searches",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(searches)
+	}
+}
+
+// CreateSavedSearch saves a search query under a name. The query is validated
+// when saved, so saved searches always run.
+func CreateSavedSearch(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		req, ok := decodeSavedSearchRequest(w, r)
+		if !ok {
+			return
+		}
+
+		saved := models.SavedSearch{Name: req.Name, Query: req.Query}
+		err := cb.Call(func() error {
+			return db.QueryRow(
+				"INSERT INTO saved_searches (user_id, name, query) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at",
+				userID, req.Name, req.Query,
+			).Scan(&saved.ID, &saved.CreatedAt, &saved.UpdatedAt)
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to save search",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(saved)
+	}
+}
+
+// UpdateSavedSearch renames a saved search or changes its query
+func UpdateSavedSearch(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		searchID, ok := parseSavedSearchID(w, r)
+		if !ok {
+			return
+		}
+
+		req, ok := decodeSavedSearchRequest(w, r)
+		if !ok {
+			return
+		}
+
+		saved := models.SavedSearch{ID: searchID, Name: req.Name, Query: req.Query}
+		err := cb.Call(func() error {
+			return db.QueryRow(
+				`UPDATE saved_searches SET name = $1, query = $2, updated_at = CURRENT_TIMESTAMP
+				WHERE id = $3 AND user_id = $4 RETURNING created_at, updated_at`,
+				req.Name, req.Query, searchID, userID,
+			).Scan(&saved.CreatedAt, &saved.UpdatedAt)
+		})
+
+		if err == sql.ErrNoRows {
+			writeSavedSearchNotFound(w)
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to update saved search",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(saved)
+	}
+}
+
+// DeleteSavedSearch deletes a saved search
+func DeleteSavedSearch(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		searchID, ok := parseSavedSearchID(w, r)
+		if !ok {
+			return
+		}
+
+		var result sql.Result
+		err := cb.Call(func() error {
+			var err error
+			result, err = db.Exec("DELETE FROM saved_searches WHERE id = $1 AND user_id = $2", searchID, userID)
+			return err
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to delete saved search",
+			})
+			return
+		}
+
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			writeSavedSearchNotFound(w)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Saved search deleted successfully"})
+	}
+}
+
+// RunSavedSearch runs a saved search in the current workspace, taking the same
+// page and per_page parameters as SearchNotes
+func RunSavedSearch(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		searchID, ok := parseSavedSearchID(w, r)
+		if !ok {
+			return
+		}
+
+		var q string
+		err := cb.Call(func() error {
+			return db.QueryRow("SELECT query FROM saved_searches WHERE id = $1 AND user_id = $2", searchID, userID).Scan(&q)
+		})
+
+		if err == sql.ErrNoRows {
+			writeSavedSearchNotFound(w)
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to search notes",
+			})
+			return
+		}
+
+		query, ok := parseSearchQuery(w, q)
+		if !ok {
+			return
+		}
+
+		writeSearchResults(w, r, db, cb, query)
+	}
+}
+
+// decodeSavedSearchRequest reads and validates a saved search, writing a 400 if it is invalid
+func decodeSavedSearchRequest(w http.ResponseWriter, r *http.Request) (models.SavedSearchRequest, bool) {
+	var req models.SavedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Failed to parse request body",
+		})
+		return req, false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > savedSearchNameMaxLength {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("Name is required and must be at most %d characters", savedSearchNameMaxLength),
+		})
+		return req, false
+	}
+
+	req.Query = strings.TrimSpace(req.Query)
+	if _, ok := parseSearchQuery(w, req.Query); !ok {
+		return req, false
+	}
+
+	return req, true
+}
+
+func writeSavedSearchNotFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   "not_found",
+		Message: "Saved search not found",
+	})
+}
+
+// parseSavedSearchID reads the {search_id} path variable, writing a 400 if it is invalid
+func parseSavedSearchID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	searchID, err := strconv.Atoi(mux.Vars(r)["search_id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Invalid saved search ID",
+		})
+		return 0, false
+	}
+	return searchID, true
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"vicnotes/backend/models"
+	"vicnotes/backend/search"
+	"vicnotes/backend/utils"
+)
+
+const (
+	searchDefaultPerPage = 20
+	searchMaxPerPage     = 100
+
+	// ts_headline marks matches with these control characters, which are
+	// stripped from the note text first and become <mark> tags once it is escaped
+	highlightStart = "\x01"
+	highlightStop  = "\x02"
+)
+
+// highlightMarkup escapes a snippet as HTML, turning its highlights into <mark> tags
+var highlightMarkup = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// SearchNotes searches the current workspace's notes with a query such as
+// tag:work is:pinned "exact phrase" -draft, best matches first. Text terms use
+// Postgres full-text search in the caller's search language.
+func SearchNotes(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		query, ok := parseSearchQuery(w, r.URL.Query().Get("q"))
+		if !ok {
+			return
+		}
+
+		writeSearchResults(w, r, db, cb, query)
+	}
+}
+
+// UpdateSearchLanguage sets the language the caller's new notes are indexed in and
+// re-indexes the notes of their personal workspace. Notes in shared workspaces keep
+// their language, so other members' searches don't change.
+func UpdateSearchLanguage(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		var req models.SearchLanguageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		var known bool
+		err := cb.Call(func() error {
+			if err := db.QueryRow(
+				"SELECT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = $1)",
+				req.Language,
+			).Scan(&known); err != nil || !known {
+				return err
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if _, err := tx.Exec("UPDATE users SET search_language = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", req.Language, userID); err != nil {
+				return err
+			}
+
+			// search_vector is generated from search_language, so this re-indexes the notes
+			if _, err := tx.Exec(
+				"UPDATE notes SET search_language = $1::regconfig WHERE workspace_id = (SELECT id FROM workspaces WHERE personal_user_id = $2)",
+				req.Language, userID,
+			); err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to update search language",
+			})
+			return
+		}
+
+		if !known {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Unknown search language",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Search language updated successfully"})
+	}
+}
+
+// parseSearchQuery parses a search query, writing a 400 if it is invalid or empty
+func parseSearchQuery(w http.ResponseWriter, q string) (*search.And, bool) {
+	query, err := search.Parse(q)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_query",
+			Message: err.Error(),
+		})
+		return nil, false
+	}
+
+	if len(query.Terms) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_query",
+			Message: "The query must contain at least one word or filter",
+		})
+		return nil, false
+	}
+
+	return query, true
+}
+
+// writeSearchResults writes a page of the current workspace's notes matching query.
+// Notes are ranked and highlighted by the query's text terms; queries made only of
+// filters list the most recently updated notes first.
+func writeSearchResults(w http.ResponseWriter, r *http.Request, db *sql.DB, cb *utils.CircuitBreaker, query *search.And) {
+	workspaceID, _ := currentWorkspace(r)
+
+	page, perPage, ok := parsePagination(r, searchDefaultPerPage, searchMaxPerPage)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "page and per_page must be positive integers",
+		})
+		return
+	}
+
+	results := models.NoteSearchResults{Results: []models.NoteSearchResult{}, Page: page, PerPage: perPage}
+	err := cb.Call(func() error {
+		compiler := search.Compiler{Args: []interface{}{workspaceID}}
+		where := "n.workspace_id = $1 AND n.deleted_at IS NULL AND " + compiler.Compile(query)
+
+		if err := db.QueryRow("SELECT COUNT(*) FROM notes n WHERE "+where, compiler.Args...).Scan(&results.Total); err != nil {
+			return err
+		}
+
+		args := append(compiler.Args, highlightStart+highlightStop)
+		markers := len(args)
+		ranking := fmt.Sprintf(
+			"0::real AS rank, translate(n.title, $%[1]d, ''), LEFT(translate(COALESCE(n.content, ''), $%[1]d, ''), 200) FROM notes n",
+			markers,
+		)
+		if rankQuery := search.RankQuery(query); rankQuery != "" {
+			selectors := fmt.Sprintf("StartSel=%s, StopSel=%s", highlightStart, highlightStop)
+			args = append(args, rankQuery, selectors+", HighlightAll=true", selectors+", MaxFragments=2, MaxWords=20, MinWords=5")
+			ranking = fmt.Sprintf(
+				`ts_rank_cd(n.search_vector, q) AS rank,
+				ts_headline(n.search_language, translate(n.title, $%[1]d, ''), q, $%[3]d),
+				ts_headline(n.search_language, translate(COALESCE(n.content, ''), $%[1]d, ''), q, $%[4]d)
+				FROM notes n, to_tsquery(n.search_language, $%[2]d) q`,
+				markers, markers+1, markers+2, markers+3,
+			)
+		}
+		args = append(args, perPage, (page-1)*perPage)
+
+		rows, err := db.Query(
+			fmt.Sprintf(
+				"SELECT %s, %s WHERE %s ORDER BY rank DESC, n.updated_at DESC LIMIT $%d OFFSET $%d",
+				noteColumns, ranking, where, len(args)-1, len(args),
+			),
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var notes []models.Note
+		for rows.Next() {
+			var result models.NoteSearchResult
+			if err := scanNote(rows, &result.Note, &result.Rank, &result.TitleSnippet, &result.Snippet); err != nil {
+				return err
+			}
+			result.TitleSnippet = highlightMarkup.Replace(html.EscapeString(result.TitleSnippet))
+			result.Snippet = highlightMarkup.Replace(html.EscapeString(result.Snippet))
+			results.Results = append(results.Results, result)
+			notes = append(notes, result.Note)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if err := attachTags(db, notes); err != nil {
+			return err
+		}
+		for i := range notes {
+			results.Results[i].Tags = notes[i].Tags
+		}
+		return nil
+	})
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to search notes",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
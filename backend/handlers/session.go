@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"vicnotes/backend/config"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+// deliverToken hands a freshly issued session token to the client and returns
+// the token to put in the response body. In cookie session mode the token is
+// set as an HttpOnly cookie together with a new CSRF cookie instead, and the
+// body gets no token so scripts never see it.
+func deliverToken(w http.ResponseWriter, token string) string {
+	if config.GetSessionMode() != config.SessionCookie {
+		return token
+	}
+
+	csrfToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		// The session cookie alone would be unusable for writes, so fall back to a Bearer token
+		log.Printf("Failed to generate CSRF token: %v", err)
+		return token
+	}
+
+	maxAge := int(utils.TokenTTL.Seconds())
+	http.SetCookie(w, sessionCookie(config.GetSessionCookieName(), token, maxAge, true))
+	// Readable by the frontend, which echoes it in the X-CSRF-Token header
+	http.SetCookie(w, sessionCookie(config.GetCSRFCookieName(), csrfToken, maxAge, false))
+
+	return ""
+}
+
+// Logout clears the session cookies. When the request carries a valid session it
+// also revokes the user's tokens, so a copied cookie or token stops working too.
+// Expired or missing sessions are still logged out, without revoking anything.
+func Logout(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if claims := logoutClaims(r); claims != nil {
+			var result sql.Result
+			err := cb.Call(func() error {
+				var err error
+				result, err = db.Exec(
+					"UPDATE users SET token_version = token_version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND token_version = $2",
+					claims.UserID, claims.Version,
+				)
+				return err
+			})
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "server_error",
+					Message: "Failed to log out",
+				})
+				return
+			}
+
+			if revoked, _ := result.RowsAffected(); revoked > 0 {
+				cache.Delete(fmt.Sprintf("auth:%d", claims.UserID))
+				recordTokensRevoked(db, cb, r, claims.UserID, "logout")
+			}
+		}
+
+		http.SetCookie(w, sessionCookie(config.GetSessionCookieName(), "", -1, true))
+		http.SetCookie(w, sessionCookie(config.GetCSRFCookieName(), "", -1, false))
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
+	}
+}
+
+// logoutClaims returns the claims of the session a logout request carries, as the
+// auth middleware would accept it, or nil. Cookie sessions need the CSRF header, so
+// other sites can't revoke a user's sessions.
+func logoutClaims(r *http.Request) *utils.Claims {
+	var token string
+	if header := r.Header.Get("Authorization"); header != "" {
+		parts := strings.Split(header, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return nil
+		}
+		token = parts[1]
+	} else {
+		session, err := r.Cookie(config.GetSessionCookieName())
+		if err != nil || session.Value == "" {
+			return nil
+		}
+		csrf, err := r.Cookie(config.GetCSRFCookieName())
+		if err != nil || csrf.Value == "" ||
+			subtle.ConstantTimeCompare([]byte(r.Header.Get("X-CSRF-Token")), []byte(csrf.Value)) != 1 {
+			return nil
+		}
+		token = session.Value
+	}
+
+	claims, err := utils.VerifyToken(token)
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// sessionCookie builds a session or CSRF cookie; a negative maxAge deletes it
+func sessionCookie(name, value string, maxAge int, httpOnly bool) *http.Cookie {
+	sameSite := http.SameSiteLaxMode
+	switch config.GetSessionCookieSameSite() {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   config.GetSessionCookieDomain(),
+		MaxAge:   maxAge,
+		Secure:   config.SessionCookieSecure(),
+		HttpOnly: httpOnly,
+		SameSite: sameSite,
+	}
+}
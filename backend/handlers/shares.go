@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+// ShareNote shares a note with another user by email, or changes the permission
+// of an existing share. Only the note's owner and workspace owners can share.
+func ShareNote(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok || !requireShareManager(w, access, userID) {
+			return
+		}
+
+		var req models.ShareNoteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		req.Email = utils.NormalizeEmail(req.Email)
+		if req.Email == "" || (req.Permission != models.WorkspaceViewer && req.Permission != models.WorkspaceEditor) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Email and a permission of editor or viewer are required",
+			})
+			return
+		}
+
+		share := models.NoteShare{Permission: req.Permission}
+		err := cb.Call(func() error {
+			return db.QueryRow("SELECT id, email FROM users WHERE LOWER(email) = $1", req.Email).Scan(&share.UserID, &share.Email)
+		})
+
+		if err == sql.ErrNoRows {
+			writeUserNotFound(w)
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to share note",
+			})
+			return
+		}
+
+		if share.UserID == access.OwnerID || share.UserID == userID {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "A note can't be shared with its owner or with yourself",
+			})
+			return
+		}
+
+		// xmax is 0 for freshly inserted rows, which tells a new share from a changed one
+		var created bool
+		err = cb.Call(func() error {
+			return db.QueryRow(
+				`INSERT INTO note_shares (note_id, user_id, permission, created_by) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (note_id, user_id) DO UPDATE SET permission = EXCLUDED.permission
+				RETURNING created_at, xmax = 0`,
+				noteID, share.UserID, req.Permission, userID,
+			).Scan(&share.CreatedAt, &created)
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to share note",
+			})
+			return
+		}
+
+		recordAudit(db, cb, r, auditEvent{
+			UserID:     userID,
+			Action:     auditNoteShare,
+			TargetType: "note",
+			TargetID:   noteID,
+			Details:    map[string]string{"shared_with": strconv.Itoa(share.UserID), "permission": req.Permission},
+		})
+
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		json.NewEncoder(w).Encode(share)
+	}
+}
+
+// ListNoteShares lists the users a note is shared with
+func ListNoteShares(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok || !requireShareManager(w, access, userID) {
+			return
+		}
+
+		var shares []models.NoteShare
+		err := cb.Call(func() error {
+			rows, err := db.Query(
+				`SELECT s.user_id, u.email, s.permission, s.created_at FROM note_shares s
+				JOIN users u ON u.id = s.user_id
+				WHERE s.note_id = $1 ORDER BY s.created_at`,
+				noteID,
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			shares = []models.NoteShare{}
+			for rows.Next() {
+				var share models.NoteShare
+				if err := rows.Scan(&share.UserID, &share.Email, &share.Permission, &share.CreatedAt); err != nil {
+					return err
+				}
+				shares = append(shares, share)
+			}
+			return rows.Err()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch shares",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(shares)
+	}
+}
+
+// RevokeNoteShare removes a user's access to a note. The note's owner and
+// workspace owners can revoke any share, and users can give up their own.
+func RevokeNoteShare(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		sharedWith, ok := parseMemberID(w, r)
+		if !ok {
+			return
+		}
+
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok || (sharedWith != userID && !requireShareManager(w, access, userID)) {
+			return
+		}
+
+		var result sql.Result
+		err := cb.Call(func() error {
+			var err error
+			result, err = db.Exec("DELETE FROM note_shares WHERE note_id = $1 AND user_id = $2", noteID, sharedWith)
+			return err
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to revoke share",
+			})
+			return
+		}
+
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "not_found",
+				Message: "Share not found",
+			})
+			return
+		}
+
+		recordAudit(db, cb, r, auditEvent{
+			UserID:     userID,
+			Action:     auditNoteUnshare,
+			TargetType: "note",
+			TargetID:   noteID,
+			Details:    map[string]string{"shared_with": strconv.Itoa(sharedWith)},
+		})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Share revoked successfully"})
+	}
+}
+
+// writeSharedNotes writes the notes shared with the caller, most recently updated first
+func writeSharedNotes(w http.ResponseWriter, r *http.Request, db *sql.DB, cb *utils.CircuitBreaker) {
+	userID := r.Context().Value("user_id").(int)
+
+	var notes []models.Note
+	err := cb.Call(func() error {
+		rows, err := db.Query(
+			`SELECT `+noteColumns+`, s.permission
+			FROM note_shares s JOIN notes n ON n.id = s.note_id
+			WHERE s.user_id = $1 AND n.deleted_at IS NULL ORDER BY n.updated_at DESC`,
+			userID,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		notes = []models.Note{}
+		for rows.Next() {
+			var note models.Note
+			if err := scanNote(rows, &note, &note.Permission); err != nil {
+				return err
+			}
+			notes = append(notes, note)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		return attachTags(db, notes)
+	})
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to fetch notes",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(notes)
+}
+
+// requireShareManager writes a 403 unless the caller may manage the note's shares:
+// its owner, or an owner of the workspace it is in
+func requireShareManager(w http.ResponseWriter, access noteAccess, userID int) bool {
+	if access.WorkspaceRole != "" && (access.OwnerID == userID || access.WorkspaceRole == models.WorkspaceOwner) {
+		return true
+	}
+
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   "forbidden",
+		Message: "Only the note's owner can manage sharing",
+	})
+	return false
+}
+
+// parseNoteID reads the {id} path variable, writing a 400 if it is invalid
+func parseNoteID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	noteID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Invalid note ID",
+		})
+		return 0, false
+	}
+	return noteID, true
+}
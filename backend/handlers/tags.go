@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+const (
+	tagNameMaxLength = 64
+	defaultTagColor  = "#6b7280"
+)
+
+var tagColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ListTags lists the current workspace's tags with the number of notes carrying each
+func ListTags(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		workspaceID, _ := currentWorkspace(r)
+
+		var tags []models.TagUsage
+		err := cb.Call(func() error {
+			rows, err := db.Query(
+				`SELECT t.id, t.name, t.color, COUNT(nt.note_id) FROM tags t
+				LEFT JOIN (note_tags nt JOIN notes n ON n.id = nt.note_id AND n.deleted_at IS NULL) ON nt.tag_id = t.id
+				WHERE t.workspace_id = $1 GROUP BY t.id ORDER BY LOWER(t.name)`,
+				workspaceID,
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			tags = []models.TagUsage{}
+			for rows.Next() {
+				var tag models.TagUsage
+				if err := rows.Scan(&tag.ID, &tag.Name, &tag.Color, &tag.NoteCount); err != nil {
+					return err
+				}
+				tags = append(tags, tag)
+			}
+			return rows.Err()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch tags",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(tags)
+	}
+}
+
+// CreateTag creates a tag in the current workspace
+func CreateTag(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceEditor, "You don't have permission to create tags in this workspace") {
+			return
+		}
+		workspaceID, _ := currentWorkspace(r)
+
+		req, ok := decodeTagRequest(w, r)
+		if !ok {
+			return
+		}
+		if req.Color == "" {
+			req.Color = defaultTagColor
+		}
+
+		tag := models.Tag{Name: req.Name, Color: req.Color}
+		err := cb.Call(func() error {
+			return db.QueryRow(
+				"INSERT INTO tags (workspace_id, name, color) VALUES ($1, $2, $3) RETURNING id",
+				workspaceID, req.Name, req.Color,
+			).Scan(&tag.ID)
+		})
+
+		if writeTagError(w, err, "Failed to create tag") {
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(tag)
+	}
+}
+
+// UpdateTag renames or recolors a tag
+func UpdateTag(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceEditor, "You don't have permission to update tags in this workspace") {
+			return
+		}
+		workspaceID, _ := currentWorkspace(r)
+
+		tagID, ok := parseTagID(w, r)
+		if !ok {
+			return
+		}
+
+		req, ok := decodeTagRequest(w, r)
+		if !ok {
+			return
+		}
+
+		tag := models.Tag{ID: tagID}
+		err := cb.Call(func() error {
+			return db.QueryRow(
+				`UPDATE tags SET name = $1, color = COALESCE(NULLIF($2, ''), color)
+				WHERE id = $3 AND workspace_id = $4 RETURNING name, color`,
+				req.Name, req.Color, tagID, workspaceID,
+			).Scan(&tag.Name, &tag.Color)
+		})
+
+		if writeTagError(w, err, "Failed to update tag") {
+			return
+		}
+
+		invalidateTaggedNotes(db, cache, cb, workspaceID, tagID)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(tag)
+	}
+}
+
+// DeleteTag deletes a tag and removes it from every note
+func DeleteTag(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceEditor, "You don't have permission to delete tags in this workspace") {
+			return
+		}
+		workspaceID, _ := currentWorkspace(r)
+
+		tagID, ok := parseTagID(w, r)
+		if !ok {
+			return
+		}
+
+		// Tagged notes have to be looked up before the tag is gone
+		invalidateTaggedNotes(db, cache, cb, workspaceID, tagID)
+
+		var affected int64
+		err := cb.Call(func() error {
+			result, err := db.Exec("DELETE FROM tags WHERE id = $1 AND workspace_id = $2", tagID, workspaceID)
+			if err != nil {
+				return err
+			}
+			affected, err = result.RowsAffected()
+			return err
+		})
+
+		if err == nil && affected == 0 {
+			err = sql.ErrNoRows
+		}
+		if writeTagError(w, err, "Failed to delete tag") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Tag deleted successfully"})
+	}
+}
+
+// TagNote adds a tag of the note's workspace to a note
+func TagNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return setNoteTag(db, cache, cb, true)
+}
+
+// UntagNote removes a tag from a note
+func UntagNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return setNoteTag(db, cache, cb, false)
+}
+
+// setNoteTag adds or removes a note's tag. Tagging needs the editor role in the
+// note's workspace, since tags belong to it.
+func setNoteTag(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker, tagged bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		tagID, ok := parseTagID(w, r)
+		if !ok {
+			return
+		}
+
+		access, ok := loadNoteAccess(w, r, db, cb, noteID)
+		if !ok {
+			return
+		}
+
+		if !requireRole(w, access.WorkspaceRole, models.WorkspaceEditor, "You don't have permission to tag this note") {
+			return
+		}
+
+		err := cb.Call(func() error {
+			if !tagged {
+				result, err := db.Exec("DELETE FROM note_tags WHERE note_id = $1 AND tag_id = $2", noteID, tagID)
+				if err != nil {
+					return err
+				}
+				if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+					return err
+				}
+				return bumpNoteVersion(db, noteID)
+			}
+
+			result, err := db.Exec(
+				`INSERT INTO note_tags (note_id, tag_id)
+				SELECT $1, id FROM tags WHERE id = $2 AND workspace_id = $3
+				ON CONFLICT DO NOTHING`,
+				noteID, tagID, access.WorkspaceID,
+			)
+			if err != nil {
+				return err
+			}
+
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if affected > 0 {
+				return bumpNoteVersion(db, noteID)
+			}
+
+			// Nothing inserted means the tag is missing or the note already has it
+			var exists bool
+			if err := db.QueryRow(
+				"SELECT EXISTS (SELECT 1 FROM tags WHERE id = $1 AND workspace_id = $2)",
+				tagID, access.WorkspaceID,
+			).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				return sql.ErrNoRows
+			}
+			return nil
+		})
+
+		if writeTagError(w, err, "Failed to update note tags") {
+			return
+		}
+
+		// Invalidate cache
+		cache.Delete(fmt.Sprintf("note:%d", noteID))
+		cache.Delete(notesCacheKey(access.WorkspaceID))
+
+		message := "Tag removed successfully"
+		if tagged {
+			message = "Tag added successfully"
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": message})
+	}
+}
+
+// attachTags loads the tags of notes in a single query
+func attachTags(db *sql.DB, notes []models.Note) error {
+	if len(notes) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(notes))
+	index := make(map[int]int, len(notes))
+	for i := range notes {
+		notes[i].Tags = []models.Tag{}
+		ids[i] = int64(notes[i].ID)
+		index[notes[i].ID] = i
+	}
+
+	rows, err := db.Query(
+		`SELECT nt.note_id, t.id, t.name, t.color FROM note_tags nt
+		JOIN tags t ON t.id = nt.tag_id
+		WHERE nt.note_id = ANY($1) ORDER BY LOWER(t.name)`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var noteID int
+		var tag models.Tag
+		if err := rows.Scan(&noteID, &tag.ID, &tag.Name, &tag.Color); err != nil {
+			return err
+		}
+		i := index[noteID]
+		notes[i].Tags = append(notes[i].Tags, tag)
+	}
+	return rows.Err()
+}
+
+// parseTagNames splits a comma-separated list of tag names into unique lowercase names
+func parseTagNames(value string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// invalidateTaggedNotes drops the cached notes carrying a tag
+func invalidateTaggedNotes(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker, workspaceID, tagID int) {
+	cache.Delete(notesCacheKey(workspaceID))
+
+	cb.Call(func() error {
+		rows, err := db.Query("SELECT note_id FROM note_tags WHERE tag_id = $1", tagID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var noteID int
+			if err := rows.Scan(&noteID); err != nil {
+				return err
+			}
+			cache.Delete(fmt.Sprintf("note:%d", noteID))
+		}
+		return rows.Err()
+	})
+}
+
+// decodeTagRequest reads and validates a tag request, writing a 400 if it is invalid.
+// Commas aren't allowed in names since tag filters are comma-separated.
+func decodeTagRequest(w http.ResponseWriter, r *http.Request) (models.TagRequest, bool) {
+	var req models.TagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Failed to parse request body",
+		})
+		return req, false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > tagNameMaxLength || strings.Contains(req.Name, ",") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("Name is required, must be at most %d characters and can't contain commas", tagNameMaxLength),
+		})
+		return req, false
+	}
+
+	if req.Color != "" && !tagColorPattern.MatchString(req.Color) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "validation_error",
+			Message: "Color must be a hex color such as #ff8800",
+		})
+		return req, false
+	}
+	req.Color = strings.ToLower(req.Color)
+
+	return req, true
+}
+
+// writeTagError writes the response for a failed tag change.
+// It reports whether err was an error.
+func writeTagError(w http.ResponseWriter, err error, message string) bool {
+	switch {
+	case err == nil:
+		return false
+	case err == sql.ErrNoRows:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "not_found",
+			Message: "Tag not found",
+		})
+	case isUniqueViolation(err):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "tag_exists",
+			Message: "A tag with this name already exists",
+		})
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "server_error",
+			Message: message,
+		})
+	}
+	return true
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// parseTagID reads the {tag_id} path variable, writing a 400 if it is invalid
+func parseTagID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	tagID, err := strconv.Atoi(mux.Vars(r)["tag_id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Invalid tag ID",
+		})
+		return 0, false
+	}
+	return tagID, true
+}
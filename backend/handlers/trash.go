@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+// ListTrash lists the notes in the current workspace's trash, most recently deleted first
+func ListTrash(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		workspaceID, _ := currentWorkspace(r)
+
+		var notes []models.Note
+		err := cb.Call(func() error {
+			rows, err := db.Query(
+				"SELECT "+noteColumns+" FROM notes n WHERE n.workspace_id = $1 AND n.deleted_at IS NOT NULL ORDER BY n.deleted_at DESC",
+				workspaceID,
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			notes = []models.Note{}
+			for rows.Next() {
+				var note models.Note
+				if err := scanNote(rows, &note); err != nil {
+					return err
+				}
+				notes = append(notes, note)
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			return attachTags(db, notes)
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch trash",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(notes)
+	}
+}
+
+// RestoreNote takes a note out of the trash
+func RestoreNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		access, ok := loadTrashedNoteAccess(w, r, db, cb, noteID)
+		if !ok {
+			return
+		}
+
+		if !requireRole(w, access.WorkspaceRole, models.WorkspaceEditor, "You don't have permission to restore this note") {
+			return
+		}
+
+		var version int
+		err := cb.Call(func() error {
+			return db.QueryRow(
+				"UPDATE notes SET deleted_at = NULL, version = version + 1 WHERE id = $1 AND deleted_at IS NOT NULL RETURNING version",
+				noteID,
+			).Scan(&version)
+		})
+
+		if err == sql.ErrNoRows {
+			writeTrashedNoteNotFound(w)
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to restore note",
+			})
+			return
+		}
+
+		cache.Delete(fmt.Sprintf("note:%d", noteID))
+		cache.Delete(notesCacheKey(access.WorkspaceID))
+
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditNoteRestore, TargetType: "note", TargetID: noteID})
+
+		w.Header().Set("ETag", noteETag(version))
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Note restored successfully"})
+	}
+}
+
+// PurgeNote permanently deletes a note from the trash, with its revisions, shares and links
+func PurgeNote(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		noteID, ok := parseNoteID(w, r)
+		if !ok {
+			return
+		}
+
+		access, ok := loadTrashedNoteAccess(w, r, db, cb, noteID)
+		if !ok {
+			return
+		}
+
+		if !requireRole(w, access.WorkspaceRole, models.WorkspaceEditor, "You don't have permission to delete this note") {
+			return
+		}
+
+		var result sql.Result
+		err := cb.Call(func() error {
+			var err error
+			result, err = db.Exec("DELETE FROM notes WHERE id = $1 AND deleted_at IS NOT NULL", noteID)
+			return err
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to delete note",
+			})
+			return
+		}
+
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			writeTrashedNoteNotFound(w)
+			return
+		}
+
+		cache.Delete(fmt.Sprintf("note:%d", noteID))
+
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditNotePurge, TargetType: "note", TargetID: noteID})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Note permanently deleted"})
+	}
+}
+
+func writeTrashedNoteNotFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   "not_found",
+		Message: "Note not found in trash",
+	})
+}
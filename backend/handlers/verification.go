@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"vicnotes/backend/config"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+const (
+	// verifyPurposeRegister confirms the address an account was registered with
+	verifyPurposeRegister = "verify"
+	// verifyPurposeChange confirms a new address before it replaces the current one
+	verifyPurposeChange = "change"
+)
+
+var errEmailTaken = errors.New("email already in use")
+
+// sendVerificationEmail creates a verification token for email and mails the link in the background
+func sendVerificationEmail(db *sql.DB, cb *utils.CircuitBreaker, mailer utils.Mailer, userID int, email, purpose string) error {
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate verification token: %w", err)
+	}
+
+	ttl := config.GetEmailVerificationTTL()
+	err = cb.Call(func() error {
+		_, err := db.Exec(
+			"INSERT INTO email_verification_tokens (user_id, email, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second')",
+			userID, email, purpose, utils.HashToken(token), int(ttl.Seconds()),
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store verification token: %w", err)
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", config.GetAppBaseURL(), token)
+	body := fmt.Sprintf(
+		"Please confirm your email address for VicNotes.\n\n"+
+			"Use the link below to verify it. It expires in %s.\n\n%s\n\n"+
+			"If you didn't create a VicNotes account, you can ignore this email.",
+		ttl, link,
+	)
+
+	go func() {
+		if err := mailer.Send(email, "Verify your VicNotes email", body); err != nil {
+			log.Printf("Failed to send verification email: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// VerifyEmail marks a user's email as verified using a verification token
+func VerifyEmail(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req models.VerifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		if req.Token == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Token is required",
+			})
+			return
+		}
+
+		err := cb.Call(func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			var userID int
+			var email, purpose string
+			err = tx.QueryRow(
+				"SELECT user_id, email, purpose FROM email_verification_tokens WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP FOR UPDATE",
+				utils.HashToken(req.Token),
+			).Scan(&userID, &email, &purpose)
+			if err != nil {
+				return err
+			}
+
+			if purpose == verifyPurposeChange {
+				// The new address may have been registered since the change was requested
+				var taken bool
+				if err := tx.QueryRow(
+					"SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = $1 AND id <> $2)",
+					email, userID,
+				).Scan(&taken); err != nil {
+					return err
+				}
+				if taken {
+					return errEmailTaken
+				}
+
+				if _, err := tx.Exec(
+					"UPDATE users SET email = $1, email_verified = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+					email, userID,
+				); err != nil {
+					return err
+				}
+			} else {
+				// A registration token only verifies the address it was issued for
+				result, err := tx.Exec(
+					"UPDATE users SET email_verified = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND LOWER(email) = $2",
+					userID, email,
+				)
+				if err != nil {
+					return err
+				}
+				if n, _ := result.RowsAffected(); n == 0 {
+					return sql.ErrNoRows
+				}
+			}
+
+			if _, err := tx.Exec(
+				"UPDATE email_verification_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND used_at IS NULL",
+				userID,
+			); err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		})
+
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_token",
+				Message: "Verification token is invalid or has expired",
+			})
+			return
+		}
+
+		if err == errEmailTaken {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "email_taken",
+				Message: "This email is already used by another account",
+			})
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to verify email",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Email verified successfully"})
+	}
+}
+
+// ResendVerification emails a new verification link to an unverified account.
+// The response is the same whether or not the email is registered.
+func ResendVerification(db *sql.DB, cb *utils.CircuitBreaker, mailer utils.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req models.ResendVerificationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		email := utils.NormalizeEmail(req.Email)
+		if email == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Email is required",
+			})
+			return
+		}
+
+		var userID int
+		err := cb.Call(func() error {
+			return db.QueryRow(
+				"SELECT id FROM users WHERE LOWER(email) = $1 AND email_verified = FALSE",
+				email,
+			).Scan(&userID)
+		})
+
+		if err != nil && err != sql.ErrNoRows {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to query user",
+			})
+			return
+		}
+
+		if err == nil {
+			if err := sendVerificationEmail(db, cb, mailer, userID, email, verifyPurposeRegister); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(models.ErrorResponse{
+					Error:   "server_error",
+					Message: "Failed to send verification email",
+				})
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "If an unverified account exists for this email, a verification link has been sent",
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"vicnotes/backend/config"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+// noteETag is the entity tag of a note version
+func noteETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// parseIfMatch reads the note versions the If-Match header accepts, to be compared
+// with `version = ANY(...)`. It returns nil when any version is accepted: for
+// If-Match: *, or when the header is absent and NOTE_REQUIRE_IF_MATCH is off.
+// A missing required header gets a 428.
+func parseIfMatch(w http.ResponseWriter, r *http.Request) ([]int64, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if !config.RequireNoteIfMatch() {
+			return nil, true
+		}
+		w.WriteHeader(http.StatusPreconditionRequired)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "precondition_required",
+			Message: "If-Match header with the note's ETag is required",
+		})
+		return nil, false
+	}
+
+	if header == "*" {
+		return nil, true
+	}
+
+	// Weak and unknown tags never match, leaving an empty list that makes the update fail
+	versions := []int64{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		if version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions, true
+}
+
+// ifNoneMatch reports whether the If-None-Match header matches a note version,
+// in which case the client's copy is current
+func ifNoneMatch(r *http.Request, version int) bool {
+	header := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if header == "*" {
+		return true
+	}
+
+	etag := noteETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// bumpNoteVersion counts a change to a note made outside its own row, such as tagging it
+func bumpNoteVersion(db *sql.DB, noteID int) error {
+	_, err := db.Exec("UPDATE notes SET version = version + 1 WHERE id = $1", noteID)
+	return err
+}
+
+// writeNote writes a note with its ETag, or a 304 when the client already has its version
+func writeNote(w http.ResponseWriter, r *http.Request, note models.Note) {
+	w.Header().Set("ETag", noteETag(note.Version))
+	if ifNoneMatch(r, note.Version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(note)
+}
+
+// writeVersionConflict writes a 412 carrying the note's current version, for a
+// write whose If-Match didn't match. A note deleted or trashed meanwhile gets a 404.
+func writeVersionConflict(w http.ResponseWriter, db *sql.DB, cb *utils.CircuitBreaker, noteID int) {
+	var version int
+	err := cb.Call(func() error {
+		return db.QueryRow("SELECT version FROM notes WHERE id = $1 AND deleted_at IS NULL", noteID).Scan(&version)
+	})
+
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "not_found",
+			Message: "Note not found",
+		})
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to update note",
+		})
+		return
+	}
+
+	w.Header().Set("ETag", noteETag(version))
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(models.VersionConflictResponse{
+		ErrorResponse: models.ErrorResponse{
+			Error:   "version_conflict",
+			Message: "The note was changed since you read it",
+		},
+		CurrentVersion: version,
+	})
+}
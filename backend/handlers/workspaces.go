@@ -0,0 +1,710 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"vicnotes/backend/models"
+	"vicnotes/backend/utils"
+)
+
+const workspaceNameMaxLength = 255
+
+var (
+	errPersonalWorkspace = errors.New("personal workspace")
+	errLastOwner         = errors.New("last owner")
+	errNotMember         = errors.New("not a member")
+	errMemberExists      = errors.New("member exists")
+)
+
+// workspaceRoleRank orders workspace roles by the permissions they grant
+var workspaceRoleRank = map[string]int{
+	models.WorkspaceViewer: 1,
+	models.WorkspaceEditor: 2,
+	models.WorkspaceOwner:  3,
+}
+
+// currentWorkspace returns the workspace resolved by WorkspaceMiddleware and the caller's role in it
+func currentWorkspace(r *http.Request) (int, string) {
+	return r.Context().Value("workspace_id").(int), r.Context().Value("workspace_role").(string)
+}
+
+// requireWorkspaceRole writes a 403 with message unless the caller has at least
+// role in the current workspace
+func requireWorkspaceRole(w http.ResponseWriter, r *http.Request, role, message string) bool {
+	_, current := currentWorkspace(r)
+	return requireRole(w, current, role, message)
+}
+
+// requireRole writes a 403 with message unless current ranks at least as high as role
+func requireRole(w http.ResponseWriter, current, role, message string) bool {
+	if workspaceRoleRank[current] >= workspaceRoleRank[role] {
+		return true
+	}
+
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   "forbidden",
+		Message: message,
+	})
+	return false
+}
+
+// notesCacheKey is the cache key of a workspace's note list
+func notesCacheKey(workspaceID int) string {
+	return fmt.Sprintf("workspace_notes:%d", workspaceID)
+}
+
+// createPersonalWorkspace gives a new user their personal workspace inside tx
+func createPersonalWorkspace(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec(
+		`WITH workspace AS (
+			INSERT INTO workspaces (name, personal_user_id, created_by) VALUES ('Personal', $1, $1) RETURNING id
+		)
+		INSERT INTO workspace_members (workspace_id, user_id, role) SELECT id, $1, 'owner' FROM workspace`,
+		userID,
+	)
+	return err
+}
+
+// validWorkspaceRole reports whether role can be given to a member
+func validWorkspaceRole(role string) bool {
+	_, ok := workspaceRoleRank[role]
+	return ok
+}
+
+// decodeWorkspaceName reads and validates a workspace name, writing a 400 if it is invalid
+func decodeWorkspaceName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req models.WorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Failed to parse request body",
+		})
+		return "", false
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > workspaceNameMaxLength {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("Name is required and must be at most %d characters", workspaceNameMaxLength),
+		})
+		return "", false
+	}
+
+	return name, true
+}
+
+// CreateWorkspace creates a shared workspace owned by the caller
+func CreateWorkspace(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		name, ok := decodeWorkspaceName(w, r)
+		if !ok {
+			return
+		}
+
+		workspace := models.Workspace{Name: name, Role: models.WorkspaceOwner}
+		err := cb.Call(func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			err = tx.QueryRow(
+				"INSERT INTO workspaces (name, created_by) VALUES ($1, $2) RETURNING id, created_at, updated_at",
+				name, userID,
+			).Scan(&workspace.ID, &workspace.CreatedAt, &workspace.UpdatedAt)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(
+				"INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, 'owner')",
+				workspace.ID, userID,
+			); err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to create workspace",
+			})
+			return
+		}
+
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditWorkspaceCreate, TargetType: "workspace", TargetID: workspace.ID})
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(workspace)
+	}
+}
+
+// ListWorkspaces lists the workspaces the caller belongs to, personal first
+func ListWorkspaces(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		var workspaces []models.Workspace
+		err := cb.Call(func() error {
+			rows, err := db.Query(
+				`SELECT w.id, w.name, w.personal_user_id IS NOT NULL, m.role, w.created_at, w.updated_at
+				FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id
+				WHERE m.user_id = $1
+				ORDER BY w.personal_user_id IS NULL, w.name, w.id`,
+				userID,
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			workspaces = []models.Workspace{}
+			for rows.Next() {
+				var workspace models.Workspace
+				if err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.Personal, &workspace.Role, &workspace.CreatedAt, &workspace.UpdatedAt); err != nil {
+					return err
+				}
+				workspaces = append(workspaces, workspace)
+			}
+			return rows.Err()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch workspaces",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(workspaces)
+	}
+}
+
+// GetWorkspace returns the current workspace
+func GetWorkspace(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		workspaceID, role := currentWorkspace(r)
+
+		workspace := models.Workspace{ID: workspaceID, Role: role}
+		err := cb.Call(func() error {
+			return db.QueryRow(
+				"SELECT name, personal_user_id IS NOT NULL, created_at, updated_at FROM workspaces WHERE id = $1",
+				workspaceID,
+			).Scan(&workspace.Name, &workspace.Personal, &workspace.CreatedAt, &workspace.UpdatedAt)
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch workspace",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(workspace)
+	}
+}
+
+// UpdateWorkspace renames the current workspace; owners only
+func UpdateWorkspace(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceOwner, "Only owners can rename this workspace") {
+			return
+		}
+		workspaceID, _ := currentWorkspace(r)
+
+		name, ok := decodeWorkspaceName(w, r)
+		if !ok {
+			return
+		}
+
+		err := cb.Call(func() error {
+			_, err := db.Exec(
+				"UPDATE workspaces SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+				name, workspaceID,
+			)
+			return err
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to update workspace",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Workspace updated successfully"})
+	}
+}
+
+// DeleteWorkspace deletes the current workspace with all of its notes; owners only.
+// Personal workspaces can't be deleted.
+func DeleteWorkspace(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceOwner, "Only owners can delete this workspace") {
+			return
+		}
+		workspaceID, _ := currentWorkspace(r)
+
+		var affected int64
+		err := cb.Call(func() error {
+			result, err := db.Exec("DELETE FROM workspaces WHERE id = $1 AND personal_user_id IS NULL", workspaceID)
+			if err != nil {
+				return err
+			}
+			affected, err = result.RowsAffected()
+			return err
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to delete workspace",
+			})
+			return
+		}
+
+		if affected == 0 {
+			writePersonalWorkspaceError(w)
+			return
+		}
+
+		cache.Delete(notesCacheKey(workspaceID))
+		recordAudit(db, cb, r, auditEvent{UserID: userID, Action: auditWorkspaceDelete, TargetType: "workspace", TargetID: workspaceID})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Workspace deleted successfully"})
+	}
+}
+
+// ListWorkspaceMembers lists the members of the current workspace
+func ListWorkspaceMembers(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		workspaceID, _ := currentWorkspace(r)
+
+		var members []models.WorkspaceMember
+		err := cb.Call(func() error {
+			rows, err := db.Query(
+				`SELECT m.user_id, u.email, m.role, m.created_at
+				FROM workspace_members m JOIN users u ON u.id = m.user_id
+				WHERE m.workspace_id = $1
+				ORDER BY m.created_at, m.user_id`,
+				workspaceID,
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			members = []models.WorkspaceMember{}
+			for rows.Next() {
+				var member models.WorkspaceMember
+				if err := rows.Scan(&member.UserID, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+					return err
+				}
+				members = append(members, member)
+			}
+			return rows.Err()
+		})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to fetch members",
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(members)
+	}
+}
+
+// AddWorkspaceMember adds an existing user to the current workspace by email; owners only
+func AddWorkspaceMember(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceOwner, "Only owners can add members") {
+			return
+		}
+		workspaceID, _ := currentWorkspace(r)
+
+		var req models.AddWorkspaceMemberRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		req.Email = utils.NormalizeEmail(req.Email)
+		if req.Email == "" || !validWorkspaceRole(req.Role) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Email and a role of owner, editor or viewer are required",
+			})
+			return
+		}
+
+		member := models.WorkspaceMember{Role: req.Role}
+		err := cb.Call(func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if err := lockSharedWorkspace(tx, workspaceID); err != nil {
+				return err
+			}
+
+			if err := tx.QueryRow("SELECT id, email FROM users WHERE LOWER(email) = $1", req.Email).Scan(&member.UserID, &member.Email); err != nil {
+				return err
+			}
+
+			result, err := tx.Exec(
+				"INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+				workspaceID, member.UserID, req.Role,
+			)
+			if err != nil {
+				return err
+			}
+			if affected, err := result.RowsAffected(); err != nil {
+				return err
+			} else if affected == 0 {
+				return errMemberExists
+			}
+
+			return tx.Commit()
+		})
+
+		switch {
+		case err == errPersonalWorkspace:
+			writePersonalWorkspaceError(w)
+			return
+		case err == sql.ErrNoRows:
+			writeUserNotFound(w)
+			return
+		case err == errMemberExists:
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "member_exists",
+				Message: "This user is already a member of the workspace",
+			})
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to add member",
+			})
+			return
+		}
+
+		recordAudit(db, cb, r, auditEvent{
+			UserID:     userID,
+			Action:     auditWorkspaceMemberAdd,
+			TargetType: "workspace",
+			TargetID:   workspaceID,
+			Details:    map[string]string{"member_id": strconv.Itoa(member.UserID), "role": req.Role},
+		})
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(member)
+	}
+}
+
+// UpdateWorkspaceMember changes a member's role; owners only.
+// The last owner can't be demoted.
+func UpdateWorkspaceMember(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+
+		if !requireWorkspaceRole(w, r, models.WorkspaceOwner, "Only owners can change member roles") {
+			return
+		}
+		workspaceID, _ := currentWorkspace(r)
+
+		memberID, ok := parseMemberID(w, r)
+		if !ok {
+			return
+		}
+
+		var req models.UpdateWorkspaceMemberRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "Failed to parse request body",
+			})
+			return
+		}
+
+		if !validWorkspaceRole(req.Role) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{
+				Error:   "validation_error",
+				Message: "Role must be owner, editor or viewer",
+			})
+			return
+		}
+
+		err := cb.Call(func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if err := lockSharedWorkspace(tx, workspaceID); err != nil {
+				return err
+			}
+
+			if req.Role != models.WorkspaceOwner {
+				if err := ensureAnotherOwner(tx, workspaceID, memberID); err != nil {
+					return err
+				}
+			}
+
+			result, err := tx.Exec(
+				"UPDATE workspace_members SET role = $1 WHERE workspace_id = $2 AND user_id = $3",
+				req.Role, workspaceID, memberID,
+			)
+			if err != nil {
+				return err
+			}
+			if affected, err := result.RowsAffected(); err != nil {
+				return err
+			} else if affected == 0 {
+				return errNotMember
+			}
+
+			return tx.Commit()
+		})
+
+		if writeMembershipError(w, err, "Failed to update member") {
+			return
+		}
+
+		recordAudit(db, cb, r, auditEvent{
+			UserID:     userID,
+			Action:     auditWorkspaceMemberUpdate,
+			TargetType: "workspace",
+			TargetID:   workspaceID,
+			Details:    map[string]string{"member_id": strconv.Itoa(memberID), "role": req.Role},
+		})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Member updated successfully"})
+	}
+}
+
+// RemoveWorkspaceMember removes a member from the current workspace.
+// Owners can remove anyone and members can remove themselves, but the last owner can't leave.
+func RemoveWorkspaceMember(db *sql.DB, cb *utils.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID := r.Context().Value("user_id").(int)
+		workspaceID, _ := currentWorkspace(r)
+
+		memberID, ok := parseMemberID(w, r)
+		if !ok {
+			return
+		}
+
+		if memberID != userID && !requireWorkspaceRole(w, r, models.WorkspaceOwner, "Only owners can remove other members") {
+			return
+		}
+
+		err := cb.Call(func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if err := lockSharedWorkspace(tx, workspaceID); err != nil {
+				return err
+			}
+
+			if err := ensureAnotherOwner(tx, workspaceID, memberID); err != nil {
+				return err
+			}
+
+			result, err := tx.Exec(
+				"DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2",
+				workspaceID, memberID,
+			)
+			if err != nil {
+				return err
+			}
+			if affected, err := result.RowsAffected(); err != nil {
+				return err
+			} else if affected == 0 {
+				return errNotMember
+			}
+
+			return tx.Commit()
+		})
+
+		if writeMembershipError(w, err, "Failed to remove member") {
+			return
+		}
+
+		recordAudit(db, cb, r, auditEvent{
+			UserID:     userID,
+			Action:     auditWorkspaceMemberRemove,
+			TargetType: "workspace",
+			TargetID:   workspaceID,
+			Details:    map[string]string{"member_id": strconv.Itoa(memberID)},
+		})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Member removed successfully"})
+	}
+}
+
+// lockSharedWorkspace locks a workspace row for membership changes inside tx,
+// failing with errPersonalWorkspace for personal workspaces
+func lockSharedWorkspace(tx *sql.Tx, workspaceID int) error {
+	var personal bool
+	if err := tx.QueryRow(
+		"SELECT personal_user_id IS NOT NULL FROM workspaces WHERE id = $1 FOR UPDATE",
+		workspaceID,
+	).Scan(&personal); err != nil {
+		return err
+	}
+	if personal {
+		return errPersonalWorkspace
+	}
+	return nil
+}
+
+// ensureAnotherOwner fails with errLastOwner if memberID is the workspace's only owner
+func ensureAnotherOwner(tx *sql.Tx, workspaceID, memberID int) error {
+	var role string
+	err := tx.QueryRow(
+		"SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2",
+		workspaceID, memberID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return errNotMember
+	}
+	if err != nil || role != models.WorkspaceOwner {
+		return err
+	}
+
+	var otherOwners int
+	if err := tx.QueryRow(
+		"SELECT COUNT(*) FROM workspace_members WHERE workspace_id = $1 AND role = 'owner' AND user_id <> $2",
+		workspaceID, memberID,
+	).Scan(&otherOwners); err != nil {
+		return err
+	}
+	if otherOwners == 0 {
+		return errLastOwner
+	}
+	return nil
+}
+
+// writeMembershipError writes the response for a failed membership change.
+// It reports whether err was an error.
+func writeMembershipError(w http.ResponseWriter, err error, message string) bool {
+	switch {
+	case err == nil:
+		return false
+	case err == errPersonalWorkspace:
+		writePersonalWorkspaceError(w)
+	case err == errNotMember:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "not_found",
+			Message: "Member not found",
+		})
+	case err == errLastOwner:
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "last_owner",
+			Message: "A workspace needs at least one owner",
+		})
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "server_error",
+			Message: message,
+		})
+	}
+	return true
+}
+
+func writePersonalWorkspaceError(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   "personal_workspace",
+		Message: "Personal workspaces can't be shared or deleted",
+	})
+}
+
+// parseMemberID reads the {user_id} path variable, writing a 400 if it is invalid
+func parseMemberID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	memberID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Invalid user ID",
+		})
+		return 0, false
+	}
+	return memberID, true
+}
@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"vicnotes/backend/utils"
+)
+
+// StartAccountPurge periodically deletes accounts whose deletion grace period has ended.
+// Their notes in shared workspaces are handed to a workspace owner; the rest of their
+// data is removed by ON DELETE CASCADE.
+func StartAccountPurge(db *sql.DB, cb *utils.CircuitBreaker, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purgeAccounts(db, cb)
+			<-ticker.C
+		}
+	}()
+}
+
+// purgeAccounts deletes every account scheduled for deletion before now
+func purgeAccounts(db *sql.DB, cb *utils.CircuitBreaker) {
+	var userIDs []int
+	err := cb.Call(func() error {
+		rows, err := db.Query("SELECT id FROM users WHERE deletion_scheduled_at <= CURRENT_TIMESTAMP")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var userID int
+			if err := rows.Scan(&userID); err != nil {
+				return err
+			}
+			userIDs = append(userIDs, userID)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		log.Printf("Account purge failed: %v", err)
+		return
+	}
+
+	purged := 0
+	for _, userID := range userIDs {
+		if err := cb.Call(func() error { return purgeAccount(db, userID) }); err != nil {
+			log.Printf("Failed to purge account %d: %v", userID, err)
+			continue
+		}
+		purged++
+	}
+
+	if purged > 0 {
+		log.Printf("Purged %d deleted accounts", purged)
+	}
+}
+
+// purgeAccount deletes one account, keeping the shared workspaces it belongs to
+// whole: they keep an owner and the account's notes in them
+func purgeAccount(db *sql.DB, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The deletion is refused while the account is a sole owner, but ownership may have
+	// changed since. Promote the longest-standing member, editors first.
+	if _, err := tx.Exec(
+		`UPDATE workspace_members m SET role = 'owner'
+		FROM (
+			SELECT DISTINCT ON (heir.workspace_id) heir.workspace_id, heir.user_id
+			FROM workspace_members heir
+			JOIN workspaces w ON w.id = heir.workspace_id AND w.personal_user_id IS NULL
+			JOIN workspace_members leaving ON leaving.workspace_id = heir.workspace_id AND leaving.user_id = $1 AND leaving.role = 'owner'
+			WHERE heir.user_id <> $1
+			AND NOT EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspace_id = heir.workspace_id AND o.user_id <> $1 AND o.role = 'owner')
+			ORDER BY heir.workspace_id, heir.role = 'editor' DESC, heir.created_at, heir.user_id
+		) promoted
+		WHERE m.workspace_id = promoted.workspace_id AND m.user_id = promoted.user_id`,
+		userID,
+	); err != nil {
+		return err
+	}
+
+	// Shared workspaces nobody else is in go with the account
+	if _, err := tx.Exec(
+		`DELETE FROM workspaces w WHERE w.personal_user_id IS NULL
+		AND EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = w.id AND m.user_id = $1)
+		AND NOT EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = w.id AND m.user_id <> $1)`,
+		userID,
+	); err != nil {
+		return err
+	}
+
+	// Notes in the remaining shared workspaces belong to the team, so hand them to an owner
+	if _, err := tx.Exec(
+		`UPDATE notes n SET user_id = (
+			SELECT m.user_id FROM workspace_members m
+			WHERE m.workspace_id = n.workspace_id AND m.role = 'owner' AND m.user_id <> $1
+			ORDER BY m.created_at, m.user_id LIMIT 1
+		)
+		FROM workspaces w
+		WHERE w.id = n.workspace_id AND w.personal_user_id IS NULL AND n.user_id = $1`,
+		userID,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM users WHERE id = $1 AND deletion_scheduled_at <= CURRENT_TIMESTAMP", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
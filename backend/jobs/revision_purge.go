@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"vicnotes/backend/utils"
+)
+
+// StartRevisionPurge periodically deletes note revisions older than maxAge.
+// The latest revision of each note is kept whatever its age.
+func StartRevisionPurge(db *sql.DB, cb *utils.CircuitBreaker, interval, maxAge time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purgeRevisions(db, cb, maxAge)
+			<-ticker.C
+		}
+	}()
+}
+
+// purgeRevisions deletes every revision older than maxAge that isn't its note's latest
+func purgeRevisions(db *sql.DB, cb *utils.CircuitBreaker, maxAge time.Duration) {
+	var purged int64
+	err := cb.Call(func() error {
+		result, err := db.Exec(
+			`DELETE FROM note_revisions r
+			WHERE r.created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+			AND r.id < (SELECT MAX(id) FROM note_revisions latest WHERE latest.note_id = r.note_id)`,
+			maxAge.Seconds(),
+		)
+		if err != nil {
+			return err
+		}
+		purged, err = result.RowsAffected()
+		return err
+	})
+
+	if err != nil {
+		log.Printf("Revision purge failed: %v", err)
+		return
+	}
+
+	if purged > 0 {
+		log.Printf("Purged %d old note revisions", purged)
+	}
+}
@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"vicnotes/backend/utils"
+)
+
+// StartTrashPurge periodically deletes notes that have been in the trash longer than retention.
+// Their revisions, tags, shares and links are removed by ON DELETE CASCADE.
+func StartTrashPurge(db *sql.DB, cb *utils.CircuitBreaker, interval, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purgeTrash(db, cb, retention)
+			<-ticker.C
+		}
+	}()
+}
+
+// purgeTrash deletes every note deleted more than retention ago
+func purgeTrash(db *sql.DB, cb *utils.CircuitBreaker, retention time.Duration) {
+	var purged int64
+	err := cb.Call(func() error {
+		result, err := db.Exec(
+			"DELETE FROM notes WHERE deleted_at < CURRENT_TIMESTAMP - make_interval(secs => $1)",
+			retention.Seconds(),
+		)
+		if err != nil {
+			return err
+		}
+		purged, err = result.RowsAffected()
+		return err
+	})
+
+	if err != nil {
+		log.Printf("Trash purge failed: %v", err)
+		return
+	}
+
+	if purged > 0 {
+		log.Printf("Purged %d notes from the trash", purged)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"vicnotes/backend/config"
 	"vicnotes/backend/database"
 	"vicnotes/backend/handlers"
+	"vicnotes/backend/jobs"
 	"vicnotes/backend/middleware"
 	"vicnotes/backend/utils"
 )
@@ -24,6 +25,25 @@ func main() {
 	// Initialize cache
 	cache := utils.NewSimpleCache()
 
+	// Initialize mailer
+	mailer := utils.NewMailer()
+
+	// Initialize brute-force protection for logins
+	accountLimiter := utils.NewLoginLimiter(utils.LoginLimiterConfig{
+		MaxFailures:     config.GetLoginMaxFailuresPerAccount(),
+		FreeFailures:    3,
+		BaseDelay:       1 * time.Second,
+		LockoutDuration: config.GetLoginLockoutDuration(),
+		FailureWindow:   config.GetLoginLockoutDuration(),
+	})
+	ipLimiter := utils.NewLoginLimiter(utils.LoginLimiterConfig{
+		MaxFailures:     config.GetLoginMaxFailuresPerIP(),
+		FreeFailures:    10,
+		BaseDelay:       1 * time.Second,
+		LockoutDuration: config.GetLoginLockoutDuration(),
+		FailureWindow:   config.GetLoginLockoutDuration(),
+	})
+
 	// Initialize circuit breaker for database
 	dbCircuitBreaker := utils.NewCircuitBreaker(5, 2, 30*time.Second)
 
@@ -39,10 +59,33 @@ func main() {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	// Promote the users listed in ADMIN_EMAILS
+	if err := database.BootstrapAdmins(db, config.GetAdminEmails()); err != nil {
+		log.Fatalf("Failed to bootstrap admins: %v", err)
+	}
+
+	// Start background jobs
+	jobs.StartAccountPurge(db, dbCircuitBreaker, 1*time.Hour)
+	jobs.StartTrashPurge(db, dbCircuitBreaker, 1*time.Hour, config.GetTrashRetention())
+	if maxAge := config.GetNoteRevisionMaxAge(); maxAge > 0 {
+		jobs.StartRevisionPurge(db, dbCircuitBreaker, 1*time.Hour, maxAge)
+	}
+
+	// Initialize rate limiting; limits can be overridden with RATE_LIMIT_<NAME>
+	rateLimitStore, err := utils.NewRateLimitStore()
+	if err != nil {
+		log.Fatalf("Failed to initialize rate limit store: %v", err)
+	}
+	rateLimit := func(name string, requests int, window time.Duration) mux.MiddlewareFunc {
+		requests, window = config.GetRateLimit(name, requests, window)
+		return middleware.RateLimitMiddleware(rateLimitStore, name, utils.RateLimit{Requests: requests, Window: window})
+	}
+
 	// Initialize router
 	router := mux.NewRouter()
 
 	// Apply global middleware
+	router.Use(middleware.RequestIDMiddleware)
 	router.Use(middleware.LoggingMiddleware)
 	router.Use(middleware.RecoveryMiddleware)
 
@@ -51,24 +94,190 @@ func main() {
 
 	// Auth routes
 	authRouter := router.PathPrefix("/api/v1/auth").Subrouter()
-	authRouter.HandleFunc("/register", handlers.Register(db, dbCircuitBreaker)).Methods("POST")
-	authRouter.HandleFunc("/login", handlers.Login(db, dbCircuitBreaker)).Methods("POST")
+	authRouter.Use(rateLimit("auth", 30, time.Minute))
+	authRouter.Handle("/register", rateLimit("register", 5, time.Hour)(handlers.Register(db, dbCircuitBreaker, mailer))).Methods("POST")
+	authRouter.Handle("/login", rateLimit("login", 10, time.Minute)(handlers.Login(db, dbCircuitBreaker, accountLimiter, ipLimiter))).Methods("POST")
+	authRouter.HandleFunc("/logout", handlers.Logout(db, cache, dbCircuitBreaker)).Methods("POST")
+	authRouter.HandleFunc("/login/2fa", handlers.LoginMFA(db, dbCircuitBreaker, accountLimiter, ipLimiter)).Methods("POST")
+	authRouter.HandleFunc("/password/forgot", handlers.ForgotPassword(db, dbCircuitBreaker, mailer)).Methods("POST")
+	authRouter.HandleFunc("/password/reset", handlers.ResetPassword(db, cache, dbCircuitBreaker)).Methods("POST")
+	authRouter.HandleFunc("/email/verify", handlers.VerifyEmail(db, dbCircuitBreaker)).Methods("POST")
+	authRouter.HandleFunc("/email/resend", handlers.ResendVerification(db, dbCircuitBreaker, mailer)).Methods("POST")
+
+	// OpenID Connect login routes
+	if config.OIDCEnabled() {
+		oidcProvider := utils.NewOIDCProvider(
+			config.GetOIDCIssuerURL(),
+			config.GetOIDCClientID(),
+			config.GetOIDCClientSecret(),
+			config.GetOIDCRedirectURL(),
+			config.GetOIDCScopes(),
+		)
+		authRouter.HandleFunc("/oidc/login", handlers.OIDCLogin(cache, oidcProvider)).Methods("GET")
+		authRouter.HandleFunc("/oidc/callback", handlers.OIDCCallback(db, cache, dbCircuitBreaker, oidcProvider)).Methods("GET")
+	}
+
+	// Magic link login routes
+	if config.MagicLinkEnabled() {
+		magicLinkLimiter := utils.NewLoginLimiter(utils.LoginLimiterConfig{
+			MaxFailures:     config.GetMagicLinkMaxPerEmail(),
+			FreeFailures:    config.GetMagicLinkMaxPerEmail(),
+			LockoutDuration: config.GetMagicLinkWindow(),
+			FailureWindow:   config.GetMagicLinkWindow(),
+		})
+		authRouter.HandleFunc("/magic-link", handlers.RequestMagicLink(db, dbCircuitBreaker, mailer, magicLinkLimiter)).Methods("POST")
+		authRouter.HandleFunc("/magic-link/verify", handlers.MagicLinkLogin(db, dbCircuitBreaker)).Methods("POST")
+	}
+
+	// Two-factor management routes
+	mfaRouter := authRouter.PathPrefix("/2fa").Subrouter()
+	mfaRouter.Use(middleware.AuthMiddleware(db, cache, dbCircuitBreaker))
+	mfaRouter.Use(rateLimit("api", 300, time.Minute))
+	mfaRouter.HandleFunc("/setup", handlers.SetupTOTP(db, dbCircuitBreaker)).Methods("POST")
+	mfaRouter.HandleFunc("/enable", handlers.EnableTOTP(db, dbCircuitBreaker)).Methods("POST")
+	mfaRouter.HandleFunc("/disable", handlers.DisableTOTP(db, dbCircuitBreaker)).Methods("POST")
+	mfaRouter.HandleFunc("/recovery-codes", handlers.RegenerateRecoveryCodes(db, dbCircuitBreaker)).Methods("POST")
+
+	// Account routes
+	router.Handle("/api/v1/account/export/download", rateLimit("export", 30, time.Minute)(handlers.DownloadAccountExport(db, dbCircuitBreaker))).Methods("GET")
+	accountRouter := router.PathPrefix("/api/v1/account").Subrouter()
+	accountRouter.Use(middleware.AuthMiddleware(db, cache, dbCircuitBreaker))
+	accountRouter.Use(rateLimit("api", 300, time.Minute))
+	accountRouter.HandleFunc("/password", handlers.ChangePassword(db, cache, dbCircuitBreaker)).Methods("PUT")
+	accountRouter.HandleFunc("/email", handlers.ChangeEmail(db, dbCircuitBreaker, mailer)).Methods("PUT")
+	accountRouter.HandleFunc("/export", handlers.ExportAccount(db, dbCircuitBreaker)).Methods("GET")
+	accountRouter.HandleFunc("", handlers.DeleteAccount(db, dbCircuitBreaker, mailer)).Methods("DELETE")
+	accountRouter.HandleFunc("/deletion/cancel", handlers.CancelAccountDeletion(db, dbCircuitBreaker)).Methods("POST")
+	accountRouter.HandleFunc("/audit", handlers.ListAuditEvents(db, dbCircuitBreaker)).Methods("GET")
+	accountRouter.HandleFunc("/search-language", handlers.UpdateSearchLanguage(db, dbCircuitBreaker)).Methods("PUT")
+
+	// Admin routes
+	adminRouter := router.PathPrefix("/api/v1/admin").Subrouter()
+	adminRouter.Use(middleware.AuthMiddleware(db, cache, dbCircuitBreaker))
+	adminRouter.Use(middleware.AdminMiddleware(db, cache, dbCircuitBreaker))
+	adminRouter.Use(rateLimit("api", 300, time.Minute))
+	adminRouter.HandleFunc("/invites", handlers.CreateInvite(db, dbCircuitBreaker)).Methods("POST")
+	adminRouter.HandleFunc("/invites", handlers.ListInvites(db, dbCircuitBreaker)).Methods("GET")
+	adminRouter.HandleFunc("/invites/{id}", handlers.RevokeInvite(db, dbCircuitBreaker)).Methods("DELETE")
+	adminRouter.HandleFunc("/users", handlers.ListUsers(db, dbCircuitBreaker)).Methods("GET")
+	adminRouter.HandleFunc("/users/{id}", handlers.GetUser(db, dbCircuitBreaker)).Methods("GET")
+	adminRouter.HandleFunc("/users/{id}/disable", handlers.DisableUser(db, cache, dbCircuitBreaker)).Methods("POST")
+	adminRouter.HandleFunc("/users/{id}/enable", handlers.EnableUser(db, cache, dbCircuitBreaker)).Methods("POST")
+	adminRouter.HandleFunc("/users/{id}/force-password-reset", handlers.ForcePasswordReset(db, cache, dbCircuitBreaker, mailer)).Methods("POST")
+	adminRouter.HandleFunc("/users/{id}/role", handlers.UpdateUserRole(db, cache, dbCircuitBreaker)).Methods("PUT")
+	adminRouter.HandleFunc("/audit", handlers.ListAllAuditEvents(db, dbCircuitBreaker)).Methods("GET")
+
+	// Public share links, served without authentication
+	publicRouter := router.PathPrefix("/api/v1/public").Subrouter()
+	publicRouter.Use(rateLimit("public", 60, time.Minute))
+	publicRouter.HandleFunc("/notes/{token}", handlers.GetPublicNote(db, dbCircuitBreaker)).Methods("GET")
+
+	// Notes routes are served both under /api/v1/notes, where the workspace comes from
+	// the X-Workspace-ID header or defaults to the personal one, and under
+	// /api/v1/workspaces/{workspace_id}/notes
+	registerNoteRoutes := func(r *mux.Router) {
+		r.Handle("", rateLimit("note_create", 60, time.Minute)(handlers.CreateNote(db, cache, dbCircuitBreaker))).Methods("POST")
+		r.HandleFunc("", handlers.ListNotes(db, cache, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("/search", handlers.SearchNotes(db, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("/trash", handlers.ListTrash(db, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("/trash/{id}", handlers.PurgeNote(db, cache, dbCircuitBreaker)).Methods("DELETE")
+		r.HandleFunc("/{id}", handlers.GetNote(db, cache, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("/{id}", handlers.UpdateNote(db, cache, dbCircuitBreaker)).Methods("PUT")
+		r.HandleFunc("/{id}", handlers.PatchNote(db, cache, dbCircuitBreaker)).Methods("PATCH")
+		r.HandleFunc("/{id}", handlers.DeleteNote(db, cache, dbCircuitBreaker)).Methods("DELETE")
+		r.HandleFunc("/{id}/restore", handlers.RestoreNote(db, cache, dbCircuitBreaker)).Methods("POST")
+		r.HandleFunc("/{id}/shares", handlers.ListNoteShares(db, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("/{id}/shares", handlers.ShareNote(db, dbCircuitBreaker)).Methods("POST")
+		r.HandleFunc("/{id}/shares/{user_id}", handlers.RevokeNoteShare(db, dbCircuitBreaker)).Methods("DELETE")
+		r.HandleFunc("/{id}/links", handlers.ListNoteLinks(db, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("/{id}/links", handlers.CreateNoteLink(db, dbCircuitBreaker)).Methods("POST")
+		r.HandleFunc("/{id}/links/{link_id}", handlers.RevokeNoteLink(db, dbCircuitBreaker)).Methods("DELETE")
+		r.HandleFunc("/{id}/tags/{tag_id}", handlers.TagNote(db, cache, dbCircuitBreaker)).Methods("PUT")
+		r.HandleFunc("/{id}/tags/{tag_id}", handlers.UntagNote(db, cache, dbCircuitBreaker)).Methods("DELETE")
+		r.HandleFunc("/{id}/notebook", handlers.MoveNote(db, cache, dbCircuitBreaker)).Methods("PUT")
+		r.HandleFunc("/{id}/revisions", handlers.ListNoteRevisions(db, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("/{id}/revisions/diff", handlers.DiffNoteRevisions(db, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("/{id}/revisions/{revision_id}", handlers.GetNoteRevision(db, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("/{id}/revisions/{revision_id}/restore", handlers.RestoreNoteRevision(db, cache, dbCircuitBreaker)).Methods("POST")
+	}
+
+	registerTagRoutes := func(r *mux.Router) {
+		r.HandleFunc("", handlers.ListTags(db, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("", handlers.CreateTag(db, dbCircuitBreaker)).Methods("POST")
+		r.HandleFunc("/{tag_id}", handlers.UpdateTag(db, cache, dbCircuitBreaker)).Methods("PUT")
+		r.HandleFunc("/{tag_id}", handlers.DeleteTag(db, cache, dbCircuitBreaker)).Methods("DELETE")
+	}
+
+	registerNotebookRoutes := func(r *mux.Router) {
+		r.HandleFunc("", handlers.ListNotebooks(db, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("", handlers.CreateNotebook(db, dbCircuitBreaker)).Methods("POST")
+		r.HandleFunc("/{notebook_id}", handlers.RenameNotebook(db, dbCircuitBreaker)).Methods("PUT")
+		r.HandleFunc("/{notebook_id}", handlers.DeleteNotebook(db, cache, dbCircuitBreaker)).Methods("DELETE")
+		r.HandleFunc("/{notebook_id}/move", handlers.MoveNotebook(db, dbCircuitBreaker)).Methods("POST")
+	}
+
+	// Saved searches belong to the user; running one searches the current workspace
+	registerSavedSearchRoutes := func(r *mux.Router) {
+		r.HandleFunc("", handlers.ListSavedSearches(db, dbCircuitBreaker)).Methods("GET")
+		r.HandleFunc("", handlers.CreateSavedSearch(db, dbCircuitBreaker)).Methods("POST")
+		r.HandleFunc("/{search_id}", handlers.UpdateSavedSearch(db, dbCircuitBreaker)).Methods("PUT")
+		r.HandleFunc("/{search_id}", handlers.DeleteSavedSearch(db, dbCircuitBreaker)).Methods("DELETE")
+		r.HandleFunc("/{search_id}/run", handlers.RunSavedSearch(db, dbCircuitBreaker)).Methods("GET")
+	}
 
 	// Protected routes
 	notesRouter := router.PathPrefix("/api/v1/notes").Subrouter()
-	notesRouter.Use(middleware.AuthMiddleware)
-	notesRouter.HandleFunc("", handlers.CreateNote(db, cache, dbCircuitBreaker)).Methods("POST")
-	notesRouter.HandleFunc("", handlers.ListNotes(db, cache, dbCircuitBreaker)).Methods("GET")
-	notesRouter.HandleFunc("/{id}", handlers.GetNote(db, cache, dbCircuitBreaker)).Methods("GET")
-	notesRouter.HandleFunc("/{id}", handlers.UpdateNote(db, cache, dbCircuitBreaker)).Methods("PUT")
-	notesRouter.HandleFunc("/{id}", handlers.DeleteNote(db, cache, dbCircuitBreaker)).Methods("DELETE")
+	notesRouter.Use(middleware.AuthMiddleware(db, cache, dbCircuitBreaker))
+	notesRouter.Use(rateLimit("api", 300, time.Minute))
+	notesRouter.Use(middleware.WorkspaceMiddleware(db, dbCircuitBreaker))
+	registerNoteRoutes(notesRouter)
+
+	tagsRouter := router.PathPrefix("/api/v1/tags").Subrouter()
+	tagsRouter.Use(middleware.AuthMiddleware(db, cache, dbCircuitBreaker))
+	tagsRouter.Use(rateLimit("api", 300, time.Minute))
+	tagsRouter.Use(middleware.WorkspaceMiddleware(db, dbCircuitBreaker))
+	registerTagRoutes(tagsRouter)
+
+	notebooksRouter := router.PathPrefix("/api/v1/notebooks").Subrouter()
+	notebooksRouter.Use(middleware.AuthMiddleware(db, cache, dbCircuitBreaker))
+	notebooksRouter.Use(rateLimit("api", 300, time.Minute))
+	notebooksRouter.Use(middleware.WorkspaceMiddleware(db, dbCircuitBreaker))
+	registerNotebookRoutes(notebooksRouter)
+
+	searchesRouter := router.PathPrefix("/api/v1/searches").Subrouter()
+	searchesRouter.Use(middleware.AuthMiddleware(db, cache, dbCircuitBreaker))
+	searchesRouter.Use(rateLimit("api", 300, time.Minute))
+	searchesRouter.Use(middleware.WorkspaceMiddleware(db, dbCircuitBreaker))
+	registerSavedSearchRoutes(searchesRouter)
+
+	// Workspace routes
+	workspacesRouter := router.PathPrefix("/api/v1/workspaces").Subrouter()
+	workspacesRouter.Use(middleware.AuthMiddleware(db, cache, dbCircuitBreaker))
+	workspacesRouter.Use(rateLimit("api", 300, time.Minute))
+	workspacesRouter.HandleFunc("", handlers.CreateWorkspace(db, dbCircuitBreaker)).Methods("POST")
+	workspacesRouter.HandleFunc("", handlers.ListWorkspaces(db, dbCircuitBreaker)).Methods("GET")
+
+	workspaceRouter := workspacesRouter.PathPrefix("/{workspace_id:[0-9]+}").Subrouter()
+	workspaceRouter.Use(middleware.WorkspaceMiddleware(db, dbCircuitBreaker))
+	workspaceRouter.HandleFunc("", handlers.GetWorkspace(db, dbCircuitBreaker)).Methods("GET")
+	workspaceRouter.HandleFunc("", handlers.UpdateWorkspace(db, dbCircuitBreaker)).Methods("PUT")
+	workspaceRouter.HandleFunc("", handlers.DeleteWorkspace(db, cache, dbCircuitBreaker)).Methods("DELETE")
+	workspaceRouter.HandleFunc("/members", handlers.ListWorkspaceMembers(db, dbCircuitBreaker)).Methods("GET")
+	workspaceRouter.HandleFunc("/members", handlers.AddWorkspaceMember(db, dbCircuitBreaker)).Methods("POST")
+	workspaceRouter.HandleFunc("/members/{user_id}", handlers.UpdateWorkspaceMember(db, dbCircuitBreaker)).Methods("PUT")
+	workspaceRouter.HandleFunc("/members/{user_id}", handlers.RemoveWorkspaceMember(db, dbCircuitBreaker)).Methods("DELETE")
+	registerNoteRoutes(workspaceRouter.PathPrefix("/notes").Subrouter())
+	registerTagRoutes(workspaceRouter.PathPrefix("/tags").Subrouter())
+	registerNotebookRoutes(workspaceRouter.PathPrefix("/notebooks").Subrouter())
+	registerSavedSearchRoutes(workspaceRouter.PathPrefix("/searches").Subrouter())
 
 	// Get port from config
 	port := config.GetPort()
 	addr := fmt.Sprintf(":%s", port)
 
 	log.Printf("Starting VicNotes backend server on %s", addr)
-	if err := http.ListenAndServe(addr, router); err != nil {
+	// CORS wraps the router so preflights are answered before routing and auth
+	if err := http.ListenAndServe(addr, middleware.CORSMiddleware(router)); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 }
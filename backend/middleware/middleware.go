@@ -2,17 +2,59 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
+	"vicnotes/backend/config"
+	"vicnotes/backend/models"
 	"vicnotes/backend/utils"
 )
 
+// RequestIDMiddleware tags each request with an ID, reusing a sane incoming
+// X-Request-ID (e.g. from Nginx) and echoing it in the response
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			id, err := utils.GenerateRandomToken(12)
+			if err != nil {
+				id = fmt.Sprintf("%d", time.Now().UnixNano())
+			}
+			requestID = id
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+		ctx := context.WithValue(r.Context(), "request_id", requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts short IDs made of URL-safe characters only
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
 // LoggingMiddleware logs HTTP requests
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s %s", r.Method, r.RequestURI, r.RemoteAddr)
+		requestID, _ := r.Context().Value("request_id").(string)
+		log.Printf("%s %s %s request_id=%s", r.Method, r.RequestURI, r.RemoteAddr, requestID)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -31,32 +73,310 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// AuthMiddleware validates JWT tokens
-func AuthMiddleware(next http.Handler) http.Handler {
+// CORSMiddleware answers preflight requests and adds CORS headers for allowed origins.
+// It must wrap the whole router: mux only runs route middleware on a matching
+// route, and AuthMiddleware would reject preflights, which never carry credentials.
+func CORSMiddleware(next http.Handler) http.Handler {
+	allowedOrigins := make(map[string]bool)
+	allowAnyOrigin := false
+	for _, origin := range config.GetCORSAllowedOrigins() {
+		if origin == "*" {
+			allowAnyOrigin = true
+		}
+		allowedOrigins[origin] = true
+	}
+
+	// Credentials are never allowed for a wildcard origin, or every site could read a signed-in user's data
+	allowCredentials := config.CORSAllowCredentials()
+	if allowAnyOrigin && allowCredentials {
+		log.Printf("CORS_ALLOWED_ORIGINS is *, so cross-origin requests won't carry credentials")
+		allowCredentials = false
+	}
+	allowedMethods := strings.Join(config.GetCORSAllowedMethods(), ", ")
+	allowedHeaders := strings.Join(config.GetCORSAllowedHeaders(), ", ")
+	exposedHeaders := strings.Join(config.GetCORSExposedHeaders(), ", ")
+	maxAge := strconv.Itoa(int(config.GetCORSMaxAge().Seconds()))
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"error":"missing authorization header"}`))
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"error":"invalid authorization header"}`))
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !allowAnyOrigin && !allowedOrigins[strings.ToLower(origin)] {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			// Without CORS headers the browser hides the response from the page
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		claims, err := utils.VerifyToken(parts[1])
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"error":"invalid token"}`))
+		if allowAnyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if allowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
-		// Store user ID in context
-		ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		if exposedHeaders != "" {
+			w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+		}
+		next.ServeHTTP(w, r)
 	})
 }
+
+// RateLimitMiddleware limits requests to a named route group with a token bucket per caller.
+// Callers are keyed by user ID when AuthMiddleware ran first, otherwise by client IP.
+// Requests are let through if the store fails, so a Redis outage doesn't take the API down.
+func RateLimitMiddleware(store utils.RateLimitStore, name string, limit utils.RateLimit) func(http.Handler) http.Handler {
+	policy := fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Window.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		if !config.RateLimitEnabled() {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ratelimit:" + name + ":ip:" + utils.ClientIP(r)
+			if userID, ok := r.Context().Value("user_id").(int); ok {
+				key = fmt.Sprintf("ratelimit:%s:user:%d", name, userID)
+			}
+
+			result, err := store.Take(r.Context(), key, limit)
+			if err != nil {
+				log.Printf("Rate limit store error: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", policy)
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+
+			if !result.Allowed {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"error":"rate_limited","message":"Too many requests, please slow down"}`))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authState is the per-user data AuthMiddleware checks on every request
+type authState struct {
+	Role         string
+	Disabled     bool
+	TokenVersion int
+}
+
+// AuthMiddleware validates JWT tokens and rejects tokens revoked by a newer token version
+func AuthMiddleware(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// A Bearer header wins over the session cookie
+			var token string
+			fromCookie := false
+			if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+				parts := strings.Split(authHeader, " ")
+				if len(parts) != 2 || parts[0] != "Bearer" {
+					w.WriteHeader(http.StatusUnauthorized)
+					w.Write([]byte(`{"error":"invalid authorization header"}`))
+					return
+				}
+				token = parts[1]
+			} else if cookie, err := r.Cookie(config.GetSessionCookieName()); err == nil && cookie.Value != "" {
+				token = cookie.Value
+				fromCookie = true
+			} else {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"missing authorization header"}`))
+				return
+			}
+
+			// Browsers attach cookies to cross-site requests, so cookie-authenticated
+			// writes must prove they can read the CSRF cookie (double-submit)
+			if fromCookie && !isSafeMethod(r.Method) && !validCSRFToken(r) {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":"invalid csrf token"}`))
+				return
+			}
+
+			claims, err := utils.VerifyToken(token)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"invalid token"}`))
+				return
+			}
+
+			state, err := loadAuthState(db, cache, cb, claims.UserID)
+			if err == sql.ErrNoRows || (err == nil && state.TokenVersion != claims.Version) {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"token revoked"}`))
+				return
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error":"server_error"}`))
+				return
+			}
+
+			if state.Disabled {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":"account_disabled"}`))
+				return
+			}
+
+			// Store user ID in context
+			ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// csrfHeader carries the CSRF cookie's value on cookie-authenticated writes
+const csrfHeader = "X-CSRF-Token"
+
+// isSafeMethod reports whether method is read-only and exempt from CSRF checks
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// validCSRFToken reports whether the CSRF header matches the CSRF cookie
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(config.GetCSRFCookieName())
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(csrfHeader)
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1
+}
+
+// AdminMiddleware only lets through users with the admin role.
+// It must run after AuthMiddleware.
+func AdminMiddleware(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := r.Context().Value("user_id").(int)
+
+			state, err := loadAuthState(db, cache, cb, userID)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error":"server_error"}`))
+				return
+			}
+
+			if state.Role != models.RoleAdmin {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":"forbidden"}`))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WorkspaceMiddleware resolves the workspace a request works in and the caller's role there.
+// The workspace comes from the {workspace_id} path segment, then the X-Workspace-ID
+// header, and defaults to the caller's personal workspace. Non-members get 404.
+// It must run after AuthMiddleware.
+func WorkspaceMiddleware(db *sql.DB, cb *utils.CircuitBreaker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := r.Context().Value("user_id").(int)
+
+			selected := mux.Vars(r)["workspace_id"]
+			if selected == "" {
+				selected = r.Header.Get("X-Workspace-ID")
+			}
+
+			var workspaceID int
+			var role string
+			var err error
+			if selected == "" {
+				err = cb.Call(func() error {
+					return db.QueryRow(
+						`SELECT m.workspace_id, m.role FROM workspaces w
+						JOIN workspace_members m ON m.workspace_id = w.id AND m.user_id = w.personal_user_id
+						WHERE w.personal_user_id = $1`,
+						userID,
+					).Scan(&workspaceID, &role)
+				})
+			} else {
+				workspaceID, err = strconv.Atoi(selected)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(`{"error":"invalid_request","message":"Invalid workspace ID"}`))
+					return
+				}
+				err = cb.Call(func() error {
+					return db.QueryRow(
+						"SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2",
+						workspaceID, userID,
+					).Scan(&role)
+				})
+			}
+
+			if err == sql.ErrNoRows {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"not_found","message":"Workspace not found"}`))
+				return
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error":"server_error"}`))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), "workspace_id", workspaceID)
+			ctx = context.WithValue(ctx, "workspace_role", role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// loadAuthState fetches a user's auth state, caching it briefly.
+// Handlers that change it delete the "auth:<id>" cache key.
+func loadAuthState(db *sql.DB, cache *utils.SimpleCache, cb *utils.CircuitBreaker, userID int) (authState, error) {
+	cacheKey := fmt.Sprintf("auth:%d", userID)
+	if cached, ok := cache.Get(cacheKey); ok {
+		return cached.(authState), nil
+	}
+
+	var state authState
+	err := cb.Call(func() error {
+		return db.QueryRow(
+			"SELECT role, disabled_at IS NOT NULL, token_version FROM users WHERE id = $1",
+			userID,
+		).Scan(&state.Role, &state.Disabled, &state.TokenVersion)
+	})
+	if err != nil {
+		return state, err
+	}
+
+	cache.Set(cacheKey, state, 1*time.Minute)
+	return state, nil
+}
@@ -1,30 +1,55 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"vicnotes/backend/diff"
+)
+
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
 
 // User represents a user in the system
 type User struct {
-	ID        int       `json:"id"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"` // Never expose password
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                  int        `json:"id"`
+	Email               string     `json:"email"`
+	EmailVerified       bool       `json:"email_verified"`
+	TOTPEnabled         bool       `json:"totp_enabled"`
+	Role                string     `json:"role"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	Password            string     `json:"-"` // Never expose password
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
 }
 
 // Note represents a note created by a user
 type Note struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	WorkspaceID int        `json:"workspace_id"`
+	NotebookID  *int       `json:"notebook_id"`
+	Title       string     `json:"title"`
+	Content     string     `json:"content"`
+	Pinned      bool       `json:"pinned"`
+	Version     int        `json:"version"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	Tags        []Tag      `json:"tags"`
+	// Permission is set on notes listed as shared with the caller
+	Permission string `json:"permission,omitempty"`
 }
 
-// RegisterRequest represents the registration request payload
+// RegisterRequest represents the registration request payload.
+// InviteCode is only needed when registration is invite-only.
 type RegisterRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	InviteCode string `json:"invite_code"`
 }
 
 // LoginRequest represents the login request payload
@@ -33,22 +58,388 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
-// AuthResponse represents the authentication response
-type AuthResponse struct {
+// ForgotPasswordRequest represents the forgot password request payload
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest represents the reset password request payload
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// MagicLinkRequest represents the magic link request payload
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// MagicLinkLoginRequest represents the magic link exchange payload
+type MagicLinkLoginRequest struct {
 	Token string `json:"token"`
-	User  User   `json:"user"`
 }
 
-// CreateNoteRequest represents the create note request payload
+// VerifyEmailRequest represents the email verification request payload
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ResendVerificationRequest represents the resend verification email request payload
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// MFALoginRequest represents the second step of a two-factor login.
+// Either Code or RecoveryCode must be set.
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TOTPSetupResponse carries a new TOTP secret for enrollment.
+// OTPAuthURI is the payload to render as a QR code.
+type TOTPSetupResponse struct {
+	Secret         string `json:"secret"`
+	ManualEntryKey string `json:"manual_entry_key"`
+	OTPAuthURI     string `json:"otpauth_uri"`
+}
+
+// TOTPCodeRequest represents a request carrying a TOTP code
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// ReauthRequest confirms a sensitive action with the password and a second factor
+type ReauthRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// RecoveryCodesResponse carries freshly generated recovery codes
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// ChangePasswordRequest represents the change password request payload
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangeEmailRequest represents the change email request payload
+type ChangeEmailRequest struct {
+	Password string `json:"password"`
+	NewEmail string `json:"new_email"`
+}
+
+// DeleteAccountRequest represents the delete account request payload
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// DeleteAccountResponse describes a scheduled account deletion
+type DeleteAccountResponse struct {
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+	ExportURL           string    `json:"export_url"`
+}
+
+// AccountExport is a full copy of a user's data
+type AccountExport struct {
+	User       User      `json:"user"`
+	Notes      []Note    `json:"notes"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// AuthResponse represents the authentication response.
+// When MFARequired is set, Token is empty and MFAToken must be exchanged at /auth/login/2fa.
+type AuthResponse struct {
+	Token       string `json:"token,omitempty"`
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+	User        User   `json:"user"`
+}
+
+// CreateNoteRequest represents the create note request payload.
+// NotebookID is optional and files the note in a notebook of the workspace.
 type CreateNoteRequest struct {
-	Title   string `json:"title"`
-	Content string `json:"content"`
+	Title      string `json:"title"`
+	Content    string `json:"content"`
+	Pinned     bool   `json:"pinned"`
+	NotebookID *int   `json:"notebook_id"`
 }
 
-// UpdateNoteRequest represents the update note request payload
+// UpdateNoteRequest represents the update note request payload.
+// Pinned is optional and keeps the current value when omitted.
 type UpdateNoteRequest struct {
 	Title   string `json:"title"`
 	Content string `json:"content"`
+	Pinned  *bool  `json:"pinned"`
+}
+
+// InviteCode represents an admin-generated registration code.
+// Code is only returned when the invite is created.
+type InviteCode struct {
+	ID        int        `json:"id"`
+	Code      string     `json:"code,omitempty"`
+	CodeHint  string     `json:"code_hint"`
+	MaxUses   int        `json:"max_uses"`
+	UseCount  int        `json:"use_count"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy *int       `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// CreateInviteRequest represents the create invite request payload.
+// ExpiresIn is a Go duration such as "72h"; empty means the invite never expires.
+type CreateInviteRequest struct {
+	MaxUses   int    `json:"max_uses"`
+	ExpiresIn string `json:"expires_in"`
+}
+
+// AdminUser is a user as seen by admins, with usage figures
+type AdminUser struct {
+	User
+	PasswordResetRequired bool  `json:"password_reset_required"`
+	NoteCount             int   `json:"note_count"`
+	StorageBytes          int64 `json:"storage_bytes"`
+}
+
+// AdminUserList is one page of the admin user listing
+type AdminUserList struct {
+	Users   []AdminUser `json:"users"`
+	Total   int         `json:"total"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+}
+
+// UpdateRoleRequest represents the admin change role request payload
+type UpdateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// AuditEvent is one entry of the security audit log.
+// UserID is the account the event concerns and ActorID who caused it;
+// they differ for admin actions.
+type AuditEvent struct {
+	ID         int64             `json:"id"`
+	UserID     *int              `json:"user_id,omitempty"`
+	ActorID    *int              `json:"actor_id,omitempty"`
+	Action     string            `json:"action"`
+	TargetType string            `json:"target_type,omitempty"`
+	TargetID   *int              `json:"target_id,omitempty"`
+	IPAddress  string            `json:"ip_address"`
+	UserAgent  string            `json:"user_agent"`
+	RequestID  string            `json:"request_id"`
+	Details    map[string]string `json:"details,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+// AuditEventList is one page of audit events, newest first
+type AuditEventList struct {
+	Events  []AuditEvent `json:"events"`
+	Total   int          `json:"total"`
+	Page    int          `json:"page"`
+	PerPage int          `json:"per_page"`
+}
+
+// Workspace member roles
+const (
+	WorkspaceOwner  = "owner"
+	WorkspaceEditor = "editor"
+	WorkspaceViewer = "viewer"
+)
+
+// Workspace groups notes shared by its members.
+// Every user has a personal workspace that can't be shared or deleted.
+type Workspace struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Personal  bool      `json:"personal"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WorkspaceMember is a user's membership in a workspace
+type WorkspaceMember struct {
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WorkspaceRequest represents the create and rename workspace request payload
+type WorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+// AddWorkspaceMemberRequest represents the add workspace member request payload
+type AddWorkspaceMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// UpdateWorkspaceMemberRequest represents the change member role request payload
+type UpdateWorkspaceMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// Notebook is a folder of notes within a workspace. Notebooks nest through ParentID.
+type Notebook struct {
+	ID        int       `json:"id"`
+	ParentID  *int      `json:"parent_id"`
+	Name      string    `json:"name"`
+	NoteCount int       `json:"note_count"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NotebookRequest represents the create and rename notebook request payload.
+// ParentID is only used on creation; a nil ParentID creates a top-level notebook.
+type NotebookRequest struct {
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id"`
+}
+
+// MoveNotebookRequest represents the move notebook request payload; nil moves to the top level
+type MoveNotebookRequest struct {
+	ParentID *int `json:"parent_id"`
+}
+
+// MoveNoteRequest represents the move note request payload; nil takes the note out of its notebook
+type MoveNoteRequest struct {
+	NotebookID *int `json:"notebook_id"`
+}
+
+// Tag labels notes within a workspace
+type Tag struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// TagUsage is a tag with the number of notes carrying it
+type TagUsage struct {
+	Tag
+	NoteCount int `json:"note_count"`
+}
+
+// TagRequest represents the create and update tag request payload.
+// Color is a hex color such as "#ff8800"; empty keeps the current or default color.
+type TagRequest struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// NoteSearchResult is a note matching a search, with its rank and highlighted
+// snippets. Snippets are HTML-escaped note text with matches wrapped in <mark> tags.
+type NoteSearchResult struct {
+	Note
+	Rank         float64 `json:"rank"`
+	TitleSnippet string  `json:"title_snippet"`
+	Snippet      string  `json:"snippet"`
+}
+
+// NoteSearchResults is one page of search results, best matches first
+type NoteSearchResults struct {
+	Results []NoteSearchResult `json:"results"`
+	Total   int                `json:"total"`
+	Page    int                `json:"page"`
+	PerPage int                `json:"per_page"`
+}
+
+// NoteRevision is a saved version of a note's title and content. UserID is the
+// author, or null once their account is deleted.
+type NoteRevision struct {
+	ID        int       `json:"id"`
+	NoteID    int       `json:"note_id"`
+	UserID    *int      `json:"user_id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NoteRevisions is a page of a note's revisions, newest first
+type NoteRevisions struct {
+	Revisions []NoteRevision `json:"revisions"`
+	Total     int            `json:"total"`
+	Page      int            `json:"page"`
+	PerPage   int            `json:"per_page"`
+}
+
+// NoteRevisionDiff compares two revisions of a note, as a unified diff of lines
+// or as word changes. The title is compared as the first line of the text.
+type NoteRevisionDiff struct {
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Format  string        `json:"format"`
+	Unified *string       `json:"unified,omitempty"`
+	Changes []diff.Change `json:"changes,omitempty"`
+}
+
+// SavedSearch is a search query stored by a user to run again later
+type SavedSearch struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SavedSearchRequest represents the create and update saved search request payload
+type SavedSearchRequest struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// SearchLanguageRequest represents the change search language request payload.
+// Language is a Postgres text search configuration such as "english" or "simple".
+type SearchLanguageRequest struct {
+	Language string `json:"language"`
+}
+
+// NoteShare is a user a note is shared with. Permission is WorkspaceViewer or WorkspaceEditor.
+type NoteShare struct {
+	UserID     int       `json:"user_id"`
+	Email      string    `json:"email"`
+	Permission string    `json:"permission"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ShareNoteRequest represents the share note request payload
+type ShareNoteRequest struct {
+	Email      string `json:"email"`
+	Permission string `json:"permission"`
+}
+
+// NoteLink is a public read-only link to a note.
+// Token and URL are only returned when the link is created.
+type NoteLink struct {
+	ID          int        `json:"id"`
+	Token       string     `json:"token,omitempty"`
+	URL         string     `json:"url,omitempty"`
+	TokenHint   string     `json:"token_hint"`
+	HasPassword bool       `json:"has_password"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy   *int       `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// CreateNoteLinkRequest represents the create public link request payload.
+// ExpiresIn is a Go duration such as "72h"; empty means the link never expires.
+type CreateNoteLinkRequest struct {
+	ExpiresIn string `json:"expires_in"`
+	Password  string `json:"password"`
+}
+
+// PublicNote is a note as served through a public link
+type PublicNote struct {
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // ErrorResponse represents an error response
@@ -56,3 +447,10 @@ type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message"`
 }
+
+// VersionConflictResponse is the 412 response to a note write whose If-Match
+// didn't match, carrying the note's current version
+type VersionConflictResponse struct {
+	ErrorResponse
+	CurrentVersion int `json:"current_version"`
+}
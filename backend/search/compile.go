@@ -0,0 +1,101 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Compiler turns parsed queries into SQL conditions on the notes table aliased n.
+// Values are always passed as placeholders, numbered after the arguments already in Args.
+// Text terms are parsed in each note's own search language, the one it is indexed in.
+type Compiler struct {
+	Args []interface{}
+}
+
+// Compile returns a SQL condition matching the notes that match node.
+// An empty query matches every note.
+func (c *Compiler) Compile(node Node) string {
+	switch node := node.(type) {
+	case *And:
+		return c.Compile(*node)
+	case And:
+		if len(node.Terms) == 0 {
+			return "TRUE"
+		}
+		conditions := make([]string, len(node.Terms))
+		for i, term := range node.Terms {
+			conditions[i] = c.Compile(term)
+		}
+		return strings.Join(conditions, " AND ")
+	case Not:
+		return "NOT (" + c.Compile(node.Term) + ")"
+	case Text:
+		return fmt.Sprintf("n.search_vector @@ to_tsquery(n.search_language, %s)", c.arg(TSQuery(node)))
+	case Tag:
+		return fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM note_tags nt JOIN tags t ON t.id = nt.tag_id WHERE nt.note_id = n.id AND LOWER(t.name) = %s)",
+			c.arg(node.Name),
+		)
+	case Is:
+		// Parse only accepts known flags
+		return "n." + node.Flag
+	case Notebook:
+		return "n.notebook_id = " + c.arg(node.ID)
+	case Date:
+		return c.date(node)
+	default:
+		panic(fmt.Sprintf("search: unknown node %T", node))
+	}
+}
+
+// date compares a timestamp with whole days, so updated:>2026-01-01 starts on January 2nd
+func (c *Compiler) date(node Date) string {
+	column := "n.updated_at"
+	if node.Field == "created" {
+		column = "n.created_at"
+	}
+
+	day := node.Date
+	next := day.AddDate(0, 0, 1)
+	switch node.Op {
+	case ">":
+		return column + " >= " + c.arg(next)
+	case ">=":
+		return column + " >= " + c.arg(day)
+	case "<":
+		return column + " < " + c.arg(day)
+	case "<=":
+		return column + " < " + c.arg(next)
+	default:
+		return fmt.Sprintf("(%s >= %s AND %s < %s)", column, c.arg(day), column, c.arg(next))
+	}
+}
+
+// arg adds a value to Args and returns its placeholder
+func (c *Compiler) arg(value interface{}) string {
+	c.Args = append(c.Args, value)
+	return fmt.Sprintf("$%d", len(c.Args))
+}
+
+// TSQuery returns the to_tsquery expression of a text term. Only letters and
+// digits reach the expression, so tsquery operators typed by users are inert.
+// Words joined by punctuation, such as e-mail, are matched as a phrase.
+func TSQuery(node Text) string {
+	words := Words(node.Value)
+	if node.Prefix && len(words) > 0 {
+		words[len(words)-1] += ":*"
+	}
+	return strings.Join(words, " <-> ")
+}
+
+// RankQuery returns a to_tsquery expression of the query's positive text terms,
+// used to rank and highlight results. It is empty when there are none.
+func RankQuery(query *And) string {
+	var terms []string
+	for _, term := range query.Terms {
+		if text, ok := term.(Text); ok {
+			terms = append(terms, TSQuery(text))
+		}
+	}
+	return strings.Join(terms, " & ")
+}
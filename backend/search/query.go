@@ -0,0 +1,279 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Node is a node of a parsed search query
+type Node interface {
+	node()
+}
+
+// And matches notes matching every term
+type And struct {
+	Terms []Node
+}
+
+// Not matches notes not matching Term
+type Not struct {
+	Term Node
+}
+
+// Text matches notes whose title or content contain the words of Value.
+// A Phrase must match in order; a Prefix matches words starting with the last word.
+type Text struct {
+	Value  string
+	Phrase bool
+	Prefix bool
+}
+
+// Tag matches notes carrying the tag Name, compared case-insensitively
+type Tag struct {
+	Name string
+}
+
+// Is matches notes with a flag such as "pinned"
+type Is struct {
+	Flag string
+}
+
+// Notebook matches notes filed directly in a notebook
+type Notebook struct {
+	ID int
+}
+
+// Date compares the day of a note timestamp, "created" or "updated", with Date
+type Date struct {
+	Field string
+	Op    string
+	Date  time.Time
+}
+
+func (And) node()      {}
+func (Not) node()      {}
+func (Text) node()     {}
+func (Tag) node()      {}
+func (Is) node()       {}
+func (Notebook) node() {}
+func (Date) node()     {}
+
+// ParseError describes invalid query syntax. Pos is the byte offset of the problem.
+type ParseError struct {
+	Pos     int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Pos+1)
+}
+
+// isFlags are the values accepted by is:
+var isFlags = map[string]bool{
+	"pinned": true,
+}
+
+// dateOps are the comparisons accepted by created: and updated:, longest first
+var dateOps = []string{">=", "<=", ">", "<", "="}
+
+// Parse parses a search query such as
+//
+//	tag:work is:pinned updated:>2026-01-01 "exact phrase" -draft
+//
+// Terms are separated by spaces and must all match. A leading - negates a term.
+// Field values can be quoted, as in tag:"to do". Words ending in * match prefixes.
+func Parse(query string) (*And, error) {
+	p := &parser{input: query}
+	root := &And{}
+
+	for {
+		p.skipSpaces()
+		if p.done() {
+			break
+		}
+
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if term != nil {
+			root.Terms = append(root.Terms, term)
+		}
+	}
+
+	return root, nil
+}
+
+// parser reads a query left to right
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) peek() byte {
+	return p.input[p.pos]
+}
+
+func (p *parser) skipSpaces() {
+	for !p.done() && isSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+// term parses one possibly negated term. It returns nil for terms without any
+// searchable characters, such as a lone "*".
+func (p *parser) term() (Node, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+		if p.done() || isSpace(p.peek()) {
+			return nil, &ParseError{Pos: start, Message: "Expected a term after -"}
+		}
+		term, err := p.atom()
+		if err != nil || term == nil {
+			return nil, err
+		}
+		return Not{Term: term}, nil
+	}
+	return p.atom()
+}
+
+// atom parses a phrase, a field filter or a word
+func (p *parser) atom() (Node, error) {
+	start := p.pos
+	if p.peek() == '"' {
+		value, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		return text(value, true), nil
+	}
+
+	word := p.word()
+	if name, ok := fieldName(word); ok && !p.done() && p.peek() == ':' {
+		p.pos++
+		return p.field(name, start)
+	}
+
+	return text(word, false), nil
+}
+
+// field parses the value of a field filter
+func (p *parser) field(name string, start int) (Node, error) {
+	valueStart := p.pos
+	var value string
+	if !p.done() && p.peek() == '"' {
+		quoted, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		value = quoted
+	} else {
+		value = p.word()
+	}
+
+	if value == "" {
+		return nil, &ParseError{Pos: start, Message: fmt.Sprintf("Expected a value after %s:", name)}
+	}
+
+	switch name {
+	case "tag":
+		return Tag{Name: strings.ToLower(value)}, nil
+	case "is":
+		flag := strings.ToLower(value)
+		if !isFlags[flag] {
+			return nil, &ParseError{Pos: valueStart, Message: fmt.Sprintf("Unknown is: value %q", value)}
+		}
+		return Is{Flag: flag}, nil
+	case "notebook":
+		id, err := strconv.Atoi(value)
+		if err != nil || id < 1 {
+			return nil, &ParseError{Pos: valueStart, Message: "notebook: needs a notebook ID"}
+		}
+		return Notebook{ID: id}, nil
+	default:
+		return dateFilter(name, value, valueStart)
+	}
+}
+
+// quoted reads a double-quoted string, the opening quote being at the current position
+func (p *parser) quoted() (string, error) {
+	start := p.pos
+	p.pos++
+	end := strings.IndexByte(p.input[p.pos:], '"')
+	if end < 0 {
+		return "", &ParseError{Pos: start, Message: "Unterminated quote"}
+	}
+	value := p.input[p.pos : p.pos+end]
+	p.pos += end + 1
+	return value, nil
+}
+
+// word reads up to the next space, or up to a colon that ends a field name
+func (p *parser) word() string {
+	start := p.pos
+	for !p.done() && !isSpace(p.peek()) {
+		if p.peek() == ':' {
+			if _, ok := fieldName(p.input[start:p.pos]); ok {
+				break
+			}
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// isSpace reports whether b separates terms. Only ASCII whitespace counts, so
+// bytes inside multi-byte characters never split a word.
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// fieldName reports whether word names a field filter
+func fieldName(word string) (string, bool) {
+	switch name := strings.ToLower(word); name {
+	case "tag", "is", "notebook", "created", "updated":
+		return name, true
+	}
+	return "", false
+}
+
+// dateFilter parses the value of created: or updated:, such as >=2026-01-01
+func dateFilter(field, value string, pos int) (Node, error) {
+	op := "="
+	for _, candidate := range dateOps {
+		if strings.HasPrefix(value, candidate) {
+			op = candidate
+			value = value[len(candidate):]
+			break
+		}
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, &ParseError{Pos: pos, Message: fmt.Sprintf("%s: needs a date such as >2026-01-01", field)}
+	}
+	return Date{Field: field, Op: op, Date: date}, nil
+}
+
+// text builds a text term, or nil if value has nothing searchable
+func text(value string, phrase bool) Node {
+	if len(Words(value)) == 0 {
+		return nil
+	}
+	return Text{Value: value, Phrase: phrase, Prefix: !phrase && strings.HasSuffix(value, "*")}
+}
+
+// Words splits s into lowercase runs of letters and digits
+func Words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
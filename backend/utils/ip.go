@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"vicnotes/backend/config"
+)
+
+// ClientIP returns the caller's IP address.
+// With TRUST_PROXY_HEADERS enabled it is taken from the X-Real-IP header, which the
+// reverse proxy must set itself; a missing or invalid header falls back to the peer address.
+func ClientIP(r *http.Request) string {
+	if config.TrustProxyHeaders() {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -12,15 +12,77 @@ import (
 	"vicnotes/backend/config"
 )
 
-// Claims represents JWT claims
+// TokenTTL is how long a session token stays valid
+const TokenTTL = 24 * time.Hour
+
+const (
+	// PurposeMFA marks a token issued after the password step of a two-factor login
+	PurposeMFA = "mfa"
+)
+
+// Claims represents JWT claims.
+// Version must match the user's token_version, so bumping it revokes older tokens.
 type Claims struct {
-	UserID int    `json:"user_id"`
-	Email  string `json:"email"`
-	Exp    int64  `json:"exp"`
+	UserID  int    `json:"user_id"`
+	Email   string `json:"email"`
+	Version int    `json:"ver"`
+	Purpose string `json:"purpose,omitempty"`
+	Exp     int64  `json:"exp"`
 }
 
 // GenerateToken generates a JWT token
-func GenerateToken(userID int, email string) (string, error) {
+func GenerateToken(userID int, email string, version int) (string, error) {
+	return signToken(Claims{
+		UserID:  userID,
+		Email:   email,
+		Version: version,
+		Exp:     time.Now().Add(TokenTTL).Unix(),
+	})
+}
+
+// GeneratePurposeToken generates a short-lived JWT that is only accepted for the given purpose.
+// Like session tokens it carries the user's token version, for callers to check.
+func GeneratePurposeToken(userID int, email string, version int, purpose string, ttl time.Duration) (string, error) {
+	return signToken(Claims{
+		UserID:  userID,
+		Email:   email,
+		Version: version,
+		Purpose: purpose,
+		Exp:     time.Now().Add(ttl).Unix(),
+	})
+}
+
+// VerifyToken verifies and parses a JWT token
+func VerifyToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Purpose tokens must never authenticate regular requests
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("invalid token purpose")
+	}
+
+	return claims, nil
+}
+
+// VerifyPurposeToken verifies a JWT issued by GeneratePurposeToken for the given purpose
+func VerifyPurposeToken(tokenString, purpose string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Purpose != purpose {
+		return nil, fmt.Errorf("invalid token purpose")
+	}
+
+	return claims, nil
+}
+
+// signToken encodes and signs claims with the JWT secret
+func signToken(payload Claims) (string, error) {
 	secret := config.GetJWTSecret()
 
 	// Header
@@ -32,11 +94,6 @@ func GenerateToken(userID int, email string) (string, error) {
 	headerB64 := base64.RawURLEncoding.EncodeToString(headerJSON)
 
 	// Payload
-	payload := Claims{
-		UserID: userID,
-		Email:  email,
-		Exp:    time.Now().Add(24 * time.Hour).Unix(),
-	}
 	payloadJSON, _ := json.Marshal(payload)
 	payloadB64 := base64.RawURLEncoding.EncodeToString(payloadJSON)
 
@@ -50,8 +107,8 @@ func GenerateToken(userID int, email string) (string, error) {
 	return token, nil
 }
 
-// VerifyToken verifies and parses a JWT token
-func VerifyToken(tokenString string) (*Claims, error) {
+// parseToken checks the signature and expiry of a JWT and returns its claims
+func parseToken(tokenString string) (*Claims, error) {
 	secret := config.GetJWTSecret()
 
 	parts := strings.Split(tokenString, ".")
@@ -65,7 +122,7 @@ func VerifyToken(tokenString string) (*Claims, error) {
 	h.Write([]byte(message))
 	expectedSignature := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
 
-	if parts[2] != expectedSignature {
+	if !hmac.Equal([]byte(parts[2]), []byte(expectedSignature)) {
 		return nil, fmt.Errorf("invalid token signature")
 	}
 
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// LoginLimiterConfig holds brute-force protection settings
+type LoginLimiterConfig struct {
+	// MaxFailures locks the key out once reached
+	MaxFailures int
+	// FreeFailures are allowed before progressive delays start
+	FreeFailures int
+	// BaseDelay is the first delay, doubled on each further failure
+	BaseDelay time.Duration
+	// LockoutDuration is how long a key stays locked, also the delay cap
+	LockoutDuration time.Duration
+	// FailureWindow resets the count after this long without failures
+	FailureWindow time.Duration
+}
+
+// loginAttempts tracks recent failed logins for one key
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	blockedTill time.Time
+}
+
+// LoginLimiter slows down and locks out repeated failed logins per key,
+// such as an email address or a source IP
+type LoginLimiter struct {
+	mu      sync.Mutex
+	entries map[string]*loginAttempts
+	config  LoginLimiterConfig
+}
+
+// NewLoginLimiter creates a new login limiter
+func NewLoginLimiter(config LoginLimiterConfig) *LoginLimiter {
+	limiter := &LoginLimiter{
+		entries: make(map[string]*loginAttempts),
+		config:  config,
+	}
+
+	// Start cleanup goroutine
+	go limiter.cleanup()
+
+	return limiter
+}
+
+// Check returns how long the caller must wait before another attempt for key is allowed
+func (l *LoginLimiter) Check(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, exists := l.entries[key]
+	if !exists {
+		return 0
+	}
+
+	if wait := time.Until(entry.blockedTill); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Failure records a failed attempt for key.
+// It returns the failure count and whether this failure triggered a lockout.
+func (l *LoginLimiter) Failure(key string) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	entry, exists := l.entries[key]
+	if !exists || now.Sub(entry.lastFailure) > l.config.FailureWindow {
+		entry = &loginAttempts{}
+		l.entries[key] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+
+	if entry.failures >= l.config.MaxFailures {
+		entry.blockedTill = now.Add(l.config.LockoutDuration)
+		return entry.failures, entry.failures == l.config.MaxFailures
+	}
+
+	if entry.failures > l.config.FreeFailures {
+		// Progressive delay: base, 2x base, 4x base, ... capped at the lockout duration
+		delay := l.config.BaseDelay << uint(entry.failures-l.config.FreeFailures-1)
+		if delay <= 0 || delay > l.config.LockoutDuration {
+			delay = l.config.LockoutDuration
+		}
+		entry.blockedTill = now.Add(delay)
+	}
+
+	return entry.failures, false
+}
+
+// Success clears the failure history for key
+func (l *LoginLimiter) Success(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
+
+// cleanup periodically removes entries that are no longer relevant
+func (l *LoginLimiter) cleanup() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		l.mu.Lock()
+		now := time.Now()
+		for key, entry := range l.entries {
+			if now.After(entry.blockedTill) && now.Sub(entry.lastFailure) > l.config.FailureWindow {
+				delete(l.entries, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"vicnotes/backend/config"
+)
+
+// Mailer sends plain text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer creates the mailer selected by the MAIL_DRIVER setting
+func NewMailer() Mailer {
+	from := config.GetMailFrom()
+
+	switch config.GetMailDriver() {
+	case "smtp":
+		username, password := config.GetSMTPCredentials()
+		return NewSMTPMailer(config.GetSMTPAddr(), username, password, from)
+	case "file":
+		return NewFileMailer(config.GetMailDir(), from)
+	default:
+		return NewLogMailer(from)
+	}
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	addr     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer creates a new SMTP mailer
+func NewSMTPMailer(addr, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		addr:     addr,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+// Send delivers an email through the configured SMTP server
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		host := strings.Split(m.addr, ":")[0]
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+
+	msg := buildMessage(m.from, to, subject, body)
+	if err := smtp.SendMail(m.addr, auth, envelopeAddress(m.from), []string{to}, msg); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// LogMailer writes emails to the application log instead of sending them
+type LogMailer struct {
+	from string
+}
+
+// NewLogMailer creates a new log mailer
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{from: from}
+}
+
+// Send logs the email
+func (m *LogMailer) Send(to, subject, body string) error {
+	log.Printf("Mail from=%q to=%q subject=%q\n%s", m.from, to, subject, body)
+	return nil
+}
+
+// FileMailer writes each email as a .eml file in a directory
+type FileMailer struct {
+	dir     string
+	from    string
+	counter uint64
+}
+
+// NewFileMailer creates a new file mailer
+func NewFileMailer(dir, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from}
+}
+
+// Send writes the email to the mail directory
+func (m *FileMailer) Send(to, subject, body string) error {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	n := atomic.AddUint64(&m.counter, 1)
+	name := fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), n)
+	msg := buildMessage(m.from, to, subject, body)
+
+	if err := os.WriteFile(filepath.Join(m.dir, name), msg, 0o600); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	return nil
+}
+
+// buildMessage formats an RFC 5322 plain text message
+func buildMessage(from, to, subject, body string) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// envelopeAddress extracts the bare address from a "Name <addr>" sender
+func envelopeAddress(from string) string {
+	if start := strings.Index(from, "<"); start != -1 {
+		if end := strings.Index(from[start:], ">"); end != -1 {
+			return from[start+1 : start+end]
+		}
+	}
+	return from
+}
@@ -1,20 +1,143 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"vicnotes/backend/config"
+)
+
+// argon2Params holds the argon2id cost parameters stored alongside each hash
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
 )
 
-// HashPassword hashes a password using bcrypt
+// currentArgon2Params returns the configured argon2id parameters
+func currentArgon2Params() argon2Params {
+	return argon2Params{
+		memory:  uint32(config.GetArgon2Memory()),
+		time:    uint32(config.GetArgon2Time()),
+		threads: uint8(config.GetArgon2Threads()),
+	}
+}
+
+// HashPassword hashes a password with the configured algorithm (argon2id or bcrypt)
 func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
+	if config.GetPasswordHashAlgorithm() == "bcrypt" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), config.GetBcryptCost())
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
 	}
-	return string(hash), nil
+
+	return hashArgon2(password, currentArgon2Params())
 }
 
-// VerifyPassword verifies a password against its hash
+// VerifyPassword verifies a password against a bcrypt or argon2id hash
 func VerifyPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return verifyArgon2(hash, password)
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// NeedsRehash reports whether a hash was made with an outdated algorithm or cost
+func NeedsRehash(hash string) bool {
+	if config.GetPasswordHashAlgorithm() == "bcrypt" {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != config.GetBcryptCost()
+	}
+
+	params, _, _, err := decodeArgon2(hash)
+	return err != nil || params != currentArgon2Params()
+}
+
+// VerifyDummyPassword spends the same time as VerifyPassword without a real hash.
+// Call it for unknown users so response times don't reveal which emails are registered.
+func VerifyDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword("vicnotes-dummy-password")
+	})
+	VerifyPassword(dummyHash, password)
+}
+
+// hashArgon2 hashes a password into the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func hashArgon2(password string, p argon2Params) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, argon2KeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// verifyArgon2 recomputes the key with the parameters stored in the hash
+func verifyArgon2(hash, password string) bool {
+	p, salt, key, err := decodeArgon2(hash)
+	if err != nil {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// decodeArgon2 parses a PHC-formatted argon2id hash
+func decodeArgon2(hash string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version")
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 key: %w", err)
+	}
+
+	return p, salt, key, nil
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"vicnotes/backend/config"
+)
+
+// bcryptMaxBytes is the longest input bcrypt will hash
+const bcryptMaxBytes = 72
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords is the bundled blocklist, checked offline
+var commonPasswords = loadCommonPasswords(commonPasswordsFile)
+
+// loadCommonPasswords parses the blocklist into a set of lowercase entries
+func loadCommonPasswords(data string) map[string]struct{} {
+	set := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line != "" {
+			set[line] = struct{}{}
+		}
+	}
+	return set
+}
+
+// ValidatePassword checks a new password against the configured policy.
+// The returned error message is safe to show to the user.
+func ValidatePassword(password, email string) error {
+	length := utf8.RuneCountInString(password)
+
+	if min := config.GetPasswordMinLength(); length < min {
+		return fmt.Errorf("Password must be at least %d characters long", min)
+	}
+
+	if max := config.GetPasswordMaxLength(); length > max {
+		return fmt.Errorf("Password must be at most %d characters long", max)
+	}
+
+	if config.GetPasswordHashAlgorithm() == "bcrypt" && len(password) > bcryptMaxBytes {
+		return fmt.Errorf("Password must be at most %d bytes long", bcryptMaxBytes)
+	}
+
+	lower := strings.ToLower(password)
+	if _, blocked := commonPasswords[lower]; blocked {
+		return fmt.Errorf("Password is too common, please choose another one")
+	}
+
+	if email != "" && lower == strings.ToLower(email) {
+		return fmt.Errorf("Password must not be the same as your email")
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"vicnotes/backend/config"
+)
+
+// RateLimit is a token bucket holding Requests tokens that refills completely
+// over Window, allowing bursts of up to Requests
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// RateLimitResult is the outcome of taking a token from a bucket
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next token, set when not allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps token buckets
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// NewRateLimitStore creates the store selected by the RATE_LIMIT_STORE setting
+func NewRateLimitStore() (RateLimitStore, error) {
+	switch config.GetRateLimitStore() {
+	case "redis":
+		options, err := redis.ParseURL(config.GetRedisURL())
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		return NewRedisRateLimitStore(redis.NewClient(options)), nil
+	default:
+		return NewMemoryRateLimitStore(), nil
+	}
+}
+
+// bucketResult describes a bucket that holds tokens after a take attempt
+func bucketResult(limit RateLimit, tokens float64, allowed bool) RateLimitResult {
+	perToken := limit.Window / time.Duration(limit.Requests)
+
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit.Requests) - tokens) * float64(perToken)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) * float64(perToken))
+	}
+	return result
+}
+
+// tokenBucket is the state of one in-memory bucket
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	window  time.Duration
+}
+
+// MemoryRateLimitStore keeps buckets in process memory.
+// Limits are per instance, which is fine for a single backend.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewMemoryRateLimitStore creates a new in-memory rate limit store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	store := &MemoryRateLimitStore{
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	// Start cleanup goroutine
+	go store.cleanup()
+
+	return store
+}
+
+// Take takes a token from the bucket for key
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(limit.Requests)
+
+	bucket, exists := s.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		s.buckets[key] = bucket
+	}
+	bucket.window = limit.Window
+
+	// Refill for the time since the last take
+	elapsed := now.Sub(bucket.updated)
+	bucket.tokens = math.Min(capacity, bucket.tokens+elapsed.Seconds()*capacity/limit.Window.Seconds())
+	bucket.updated = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+
+	return bucketResult(limit, bucket.tokens, allowed), nil
+}
+
+// cleanup periodically removes buckets that have refilled completely
+func (s *MemoryRateLimitStore) cleanup() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		now := time.Now()
+		for key, bucket := range s.buckets {
+			if now.Sub(bucket.updated) > bucket.window {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// takeTokenScript atomically refills and takes from a bucket stored as a hash.
+// It uses the Redis clock so backend instances with skewed clocks agree.
+var takeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local window_ms = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now_ms = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or capacity
+local updated = tonumber(state[2]) or now_ms
+
+local elapsed = math.max(0, now_ms - updated)
+tokens = math.min(capacity, tokens + elapsed * capacity / window_ms)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now_ms)
+redis.call('PEXPIRE', KEYS[1], window_ms)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisRateLimitStore keeps buckets in Redis so all backend instances share limits
+type RedisRateLimitStore struct {
+	client *redis.Client
+}
+
+// NewRedisRateLimitStore creates a new Redis rate limit store
+func NewRedisRateLimitStore(client *redis.Client) *RedisRateLimitStore {
+	return &RedisRateLimitStore{client: client}
+}
+
+// Take takes a token from the bucket for key
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	reply, err := takeTokenScript.Run(ctx, s.client, []string{key}, limit.Requests, limit.Window.Milliseconds()).Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if len(reply) != 2 {
+		return RateLimitResult{}, fmt.Errorf("unexpected rate limit script reply: %v", reply)
+	}
+
+	allowed, _ := reply[0].(int64)
+	tokensText, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(tokensText, 64)
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("unexpected rate limit script reply: %v", reply)
+	}
+
+	return bucketResult(limit, tokens, allowed == 1), nil
+}
@@ -1,13 +1,35 @@
 import axios from 'axios'
 
+// In cookie session mode the backend keeps the token in an HttpOnly cookie
+export const cookieSession = import.meta.env.VITE_SESSION_MODE === 'cookie'
+const csrfCookieName = import.meta.env.VITE_CSRF_COOKIE_NAME || 'vicnotes_csrf'
+
 const api = axios.create({
   baseURL: import.meta.env.VITE_API_URL || 'http://localhost:8080',
   timeout: 10000,
+  withCredentials: cookieSession,
   headers: {
     'Content-Type': 'application/json'
   }
 })
 
+const readCookie = (name) => {
+  const match = document.cookie.split('; ').find(row => row.startsWith(`${name}=`))
+  return match ? decodeURIComponent(match.slice(name.length + 1)) : null
+}
+
+// Echo the CSRF cookie on writes so cookie-authenticated requests are accepted
+api.interceptors.request.use(config => {
+  const method = (config.method || 'get').toLowerCase()
+  if (cookieSession && !['get', 'head', 'options'].includes(method)) {
+    const csrfToken = readCookie(csrfCookieName)
+    if (csrfToken) {
+      config.headers['X-CSRF-Token'] = csrfToken
+    }
+  }
+  return config
+})
+
 // Add response interceptor for error handling
 api.interceptors.response.use(
   response => response,
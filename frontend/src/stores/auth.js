@@ -1,18 +1,22 @@
 import { defineStore } from 'pinia'
 import { ref, computed } from 'vue'
-import api from '../api/client'
+import api, { cookieSession } from '../api/client'
 
 export const useAuthStore = defineStore('auth', () => {
-  const token = ref(localStorage.getItem('token') || null)
+  // In cookie session mode the token never reaches JavaScript
+  const token = ref(cookieSession ? null : localStorage.getItem('token') || null)
   const user = ref(JSON.parse(localStorage.getItem('user') || 'null'))
 
-  const isAuthenticated = computed(() => !!token.value)
+  const isAuthenticated = computed(() => (cookieSession ? !!user.value : !!token.value))
 
   const setAuth = (newToken, newUser) => {
-    token.value = newToken
     user.value = newUser
-    localStorage.setItem('token', newToken)
     localStorage.setItem('user', JSON.stringify(newUser))
+    if (cookieSession) {
+      return
+    }
+    token.value = newToken
+    localStorage.setItem('token', newToken)
     api.defaults.headers.common['Authorization'] = `Bearer ${newToken}`
   }
 
@@ -29,6 +33,10 @@ export const useAuthStore = defineStore('auth', () => {
   }
 
   const logout = () => {
+    // Only the backend can clear the HttpOnly session cookie
+    if (cookieSession) {
+      api.post('/api/v1/auth/logout').catch(() => {})
+    }
     token.value = null
     user.value = null
     localStorage.removeItem('token')